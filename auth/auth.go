@@ -2,30 +2,151 @@
 package auth
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type Credentials struct {
 	AccessKey, SecretKey string
 }
 
+// Shown in place of the secret key when Credentials are printed.
+const REDACTED = "<redacted>"
+
+// Credentials print with the secret key redacted, so they can't end up in logs.
+func (c Credentials) String() string {
+	return fmt.Sprintf("Credentials{AccessKey: %v, SecretKey: %v}", c.AccessKey, REDACTED)
+}
+
+// Like String, for the %#v verb.
+func (c Credentials) GoString() string {
+	return fmt.Sprintf("auth.Credentials{AccessKey:%q, SecretKey:%q}", c.AccessKey, REDACTED)
+}
+
 const (
 	AWS_ACCESS_KEY_ID     = "AWS_ACCESS_KEY_ID"
 	AWS_SECRET_ACCESS_KEY = "AWS_SECRET_ACCESS_KEY"
 )
 
+// Errors for missing credentials. Functions may wrap these, so check for them with errors.Is.
+var (
+	ErrNoAccessKey = errors.New("auth: no access key")
+	ErrNoSecretKey = errors.New("auth: no secret key")
+)
+
 // Retreives a Credentials struct from environment variables AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// If either is missing, the error wraps ErrNoAccessKey or ErrNoSecretKey.
 func EnvCredentials() (cred *Credentials, err error) {
 	accessKey := os.Getenv(AWS_ACCESS_KEY_ID)
 	secretKey := os.Getenv(AWS_SECRET_ACCESS_KEY)
 	if accessKey == "" {
-		return nil, fmt.Errorf("auth.EnvCredentials: Could not find env variable %v", AWS_ACCESS_KEY_ID)
+		return nil, fmt.Errorf("auth.EnvCredentials: Could not find env variable %v: %w", AWS_ACCESS_KEY_ID, ErrNoAccessKey)
 	} else if secretKey == "" {
-		return nil, fmt.Errorf("auth.EnvCredentials: Could not find env variable %v", AWS_SECRET_ACCESS_KEY)
+		return nil, fmt.Errorf("auth.EnvCredentials: Could not find env variable %v: %w", AWS_SECRET_ACCESS_KEY, ErrNoSecretKey)
 	}
 	cred = new(Credentials)
 	cred.AccessKey = accessKey
 	cred.SecretKey = secretKey
 	return
 }
+
+const (
+	AWS_SHARED_CREDENTIALS_FILE = "AWS_SHARED_CREDENTIALS_FILE" // env variable overriding ~/.aws/credentials
+	AWS_CONFIG_FILE             = "AWS_CONFIG_FILE"             // env variable overriding ~/.aws/config
+	DEFAULT_PROFILE             = "default"
+)
+
+// Reads a profile's credentials and region from the shared files the AWS CLI uses: the keys from
+// ~/.aws/credentials (section "[name]"), and the region from ~/.aws/config (section "[profile name]",
+// but "[default]" for the default profile). Keys in the config file are used if the credentials file
+// has none. The env variables AWS_SHARED_CREDENTIALS_FILE and AWS_CONFIG_FILE override the paths.
+//
+// An empty profile is DEFAULT_PROFILE. If the profile has no region, region is "". If it has no keys,
+// the error wraps ErrNoAccessKey or ErrNoSecretKey.
+func ProfileConfig(profile string) (cred *Credentials, region string, err error) {
+	if profile == "" {
+		profile = DEFAULT_PROFILE
+	}
+	credentialsPath, err := sharedFilePath(AWS_SHARED_CREDENTIALS_FILE, "credentials")
+	if err != nil {
+		return nil, "", err
+	}
+	configPath, err := sharedFilePath(AWS_CONFIG_FILE, "config")
+	if err != nil {
+		return nil, "", err
+	}
+	credentials, err := readIniFile(credentialsPath)
+	if err != nil {
+		return nil, "", err
+	}
+	config, err := readIniFile(configPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	configSection := "profile " + profile
+	if profile == DEFAULT_PROFILE {
+		configSection = DEFAULT_PROFILE
+	}
+	keys := credentials[profile]
+	if keys["aws_access_key_id"] == "" && keys["aws_secret_access_key"] == "" {
+		keys = config[configSection]
+	}
+	if keys["aws_access_key_id"] == "" {
+		return nil, "", fmt.Errorf("auth.ProfileConfig: No aws_access_key_id for profile %v: %w", profile, ErrNoAccessKey)
+	} else if keys["aws_secret_access_key"] == "" {
+		return nil, "", fmt.Errorf("auth.ProfileConfig: No aws_secret_access_key for profile %v: %w", profile, ErrNoSecretKey)
+	}
+	cred = &Credentials{AccessKey: keys["aws_access_key_id"], SecretKey: keys["aws_secret_access_key"]}
+	return cred, config[configSection]["region"], nil
+}
+
+// Get the path of a shared file: from the env variable if set, otherwise name in ~/.aws.
+func sharedFilePath(envVar, name string) (string, error) {
+	if path := os.Getenv(envVar); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aws", name), nil
+}
+
+// Read an ini file into its sections, each a map of (lower case) keys to values. A missing file has
+// no sections.
+func readIniFile(path string) (sections map[string]map[string]string, err error) {
+	sections = make(map[string]map[string]string)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return sections, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var section map[string]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			name := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			section = sections[name]
+			if section == nil {
+				section = make(map[string]string)
+				sections[name] = section
+			}
+		case section != nil:
+			if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+				section[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+	return sections, scanner.Err()
+}
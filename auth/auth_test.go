@@ -1,8 +1,13 @@
 package auth_test
 
 import (
+	"errors"
+	"fmt"
 	"github.com/p-lewis/awsgolang/auth"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -44,6 +49,9 @@ func TestErrForMissingAccessKey(t *testing.T) {
 	if c != nil {
 		t.Errorf("Expected a nil Auth, got %v", c)
 	}
+	if !errors.Is(err, auth.ErrNoAccessKey) {
+		t.Errorf("Expected error to wrap ErrNoAccessKey, got %v", err)
+	}
 	//t.Logf("Got expected error: %v", err)
 }
 
@@ -57,5 +65,118 @@ func TestErrForMissingSecretKey(t *testing.T) {
 	if c != nil {
 		t.Errorf("Expected a nil Auth, got %v", c)
 	}
+	if !errors.Is(err, auth.ErrNoSecretKey) {
+		t.Errorf("Expected error to wrap ErrNoSecretKey, got %v", err)
+	}
+	if errors.Is(err, auth.ErrNoAccessKey) {
+		t.Errorf("Expected error not to wrap ErrNoAccessKey, got %v", err)
+	}
 	//t.Logf("Got expected error: %v", err)
 }
+
+func TestCredentialsPrintRedacted(t *testing.T) {
+	c := &auth.Credentials{AccessKey: ACCESS_KEY, SecretKey: SECRET_KEY}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		for _, val := range []interface{}{c, *c} {
+			printed := fmt.Sprintf(format, val)
+			if strings.Contains(printed, SECRET_KEY) {
+				t.Errorf("Secret key printed with %v: %v", format, printed)
+			}
+			if !strings.Contains(printed, ACCESS_KEY) {
+				t.Errorf("Access key not printed with %v: %v", format, printed)
+			}
+		}
+	}
+}
+
+const testCredentialsFile = `[default]
+aws_access_key_id = defaultAccessKey
+aws_secret_access_key = defaultSecretKey
+
+# a comment
+[work]
+aws_access_key_id=workAccessKey
+aws_secret_access_key=workSecretKey
+`
+
+const testConfigFile = `[default]
+region = us-east-1
+
+[profile work]
+region = eu-west-1
+
+[work]
+region = wrong-section-1
+
+[profile keys-in-config]
+aws_access_key_id = configAccessKey
+aws_secret_access_key = configSecretKey
+region = ap-southeast-2
+
+[profile no-keys]
+region = us-west-2
+`
+
+// Point the shared file env variables at files with the given contents, returning a function to undo it.
+func setSharedFiles(t *testing.T, credentials, config string) func() {
+	dir := t.TempDir()
+	credentialsPath := filepath.Join(dir, "credentials")
+	configPath := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(credentialsPath, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(auth.AWS_SHARED_CREDENTIALS_FILE, credentialsPath)
+	os.Setenv(auth.AWS_CONFIG_FILE, configPath)
+	return func() {
+		os.Unsetenv(auth.AWS_SHARED_CREDENTIALS_FILE)
+		os.Unsetenv(auth.AWS_CONFIG_FILE)
+	}
+}
+
+func TestProfileConfig(t *testing.T) {
+	defer setSharedFiles(t, testCredentialsFile, testConfigFile)()
+	for _, test := range []struct{ profile, accessKey, secretKey, region string }{
+		{"", "defaultAccessKey", "defaultSecretKey", "us-east-1"},
+		{"default", "defaultAccessKey", "defaultSecretKey", "us-east-1"},
+		{"work", "workAccessKey", "workSecretKey", "eu-west-1"},
+		{"keys-in-config", "configAccessKey", "configSecretKey", "ap-southeast-2"},
+	} {
+		c, region, err := auth.ProfileConfig(test.profile)
+		if err != nil {
+			t.Errorf("Profile %q: got unexpected error: %v", test.profile, err)
+			continue
+		}
+		if c.AccessKey != test.accessKey || c.SecretKey != test.secretKey {
+			t.Errorf("Profile %q: keys = %v, %v, want %v, %v", test.profile, c.AccessKey, c.SecretKey, test.accessKey, test.secretKey)
+		}
+		if region != test.region {
+			t.Errorf("Profile %q: region = %v, want %v", test.profile, region, test.region)
+		}
+	}
+}
+
+func TestProfileConfigMissing(t *testing.T) {
+	defer setSharedFiles(t, testCredentialsFile, testConfigFile)()
+	for _, profile := range []string{"no-keys", "nonexistent"} {
+		c, _, err := auth.ProfileConfig(profile)
+		if c != nil {
+			t.Errorf("Profile %q: expected nil Credentials, got %v", profile, c)
+		}
+		if !errors.Is(err, auth.ErrNoAccessKey) {
+			t.Errorf("Profile %q: expected error to wrap ErrNoAccessKey, got %v", profile, err)
+		}
+	}
+
+	// no config file: no region
+	os.Setenv(auth.AWS_CONFIG_FILE, filepath.Join(t.TempDir(), "missing"))
+	c, region, err := auth.ProfileConfig("work")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if c.AccessKey != "workAccessKey" || region != "" {
+		t.Errorf("Got %v, region %q; want workAccessKey and no region", c, region)
+	}
+}
@@ -0,0 +1,186 @@
+// Sign and send requests to any AWS service that uses Signature Version 4.
+//
+// This is the minimal core for services without their own package: build a request (NewRequest
+// resolves a path against the Endpoint), and Do signs and sends it.
+package awsclient
+
+import (
+	"errors"
+	"fmt"
+	"github.com/p-lewis/awsgolang/auth"
+	"github.com/p-lewis/awsgolang/sign4"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A Client signs requests for one service in one region, and sends them.
+type Client struct {
+	Credentials *auth.Credentials
+	Region      string       // region name for the credential scope, e.g. "us-east-1"
+	Service     string       // service name for the credential scope, e.g. "dynamodb"
+	Endpoint    string       // base URL of the service, e.g. "https://dynamodb.us-east-1.amazonaws.com"
+	HTTPClient  *http.Client // client that sends the requests; if nil, http.DefaultClient is used
+
+	// If set, the Host header sent, and signed, in place of the Endpoint's host; the connection is still
+	// made to the Endpoint (e.g. an IP address). A redirect's location replaces it.
+	Host string
+}
+
+// Returned by Do if the Client has no Service: the credential scope needs the exact service name.
+var ErrNoService = errors.New("awsclient: no service name set")
+
+// Returned by Do if the Client has no Region. There is no default: for a global endpoint, use
+// sign4.GlobalRegion.
+var ErrNoRegion = errors.New("awsclient: no region set")
+
+// Create a Client that uses http.DefaultClient. The service name (e.g. "sqs", "sns", "execute-api") is
+// used as is in the credential scope, and must not be empty.
+func New(cred *auth.Credentials, region, service, endpoint string) *Client {
+	return &Client{Credentials: cred, Region: region, Service: service, Endpoint: endpoint}
+}
+
+// Create a request for a path relative to the client's Endpoint.
+func (c *Client) NewRequest(method, path string, body io.Reader) (*http.Request, error) {
+	url := strings.TrimSuffix(c.Endpoint, "/") + "/" + strings.TrimPrefix(path, "/")
+	return http.NewRequest(method, url, body)
+}
+
+// Sign the request and send it. The request's body is read (and replaced) in the process.
+//
+// A redirect is followed once: the request is re-signed for the region of the new location (see
+// RedirectTarget) and sent there. If that fails, the error is a *RedirectError.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if c.Service == "" {
+		return nil, ErrNoService
+	}
+	if c.Region == "" {
+		return nil, ErrNoRegion
+	}
+	rreq, err := sign4.NewReusableRequestFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.Host != "" {
+		rreq.Host = c.Host
+	}
+	hreq, err := rreq.Sign(c.Credentials.AccessKey, c.Credentials.SecretKey, c.Region, c.Service)
+	if err != nil {
+		return nil, err
+	}
+	client := WithoutRedirects(c.httpClient())
+	resp, err := client.Do(hreq.WithContext(req.Context()))
+	if err != nil || !IsRedirect(resp.StatusCode) {
+		return resp, err
+	}
+
+	location, region, err := RedirectTarget(resp, c.Region)
+	if err != nil {
+		return nil, err
+	}
+	rreq.URL, rreq.Host = location, location.Host
+	hreq, err = rreq.Resign(c.Credentials.AccessKey, c.Credentials.SecretKey, region, c.Service)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = client.Do(hreq.WithContext(req.Context()))
+	if err == nil && IsRedirect(resp.StatusCode) {
+		resp.Body.Close()
+		return nil, &RedirectError{StatusCode: resp.StatusCode, Location: resp.Header.Get("Location"), Region: region}
+	}
+	return resp, err
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+// Get a copy of client that returns redirect responses rather than following them. http.Client
+// would resend the request as signed, which is wrong for a new host, and drops the body.
+func WithoutRedirects(client *http.Client) *http.Client {
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &noRedirects
+}
+
+// Whether the status code is a redirect that the request can be resent for.
+func IsRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// Get where a redirect response points: its Location, and the region to sign for there. The region
+// is taken from the "x-amz-bucket-region" header if there is one, otherwise from the Location's host
+// name (e.g. "sqs.us-west-2.amazonaws.com"). The response's body is drained and closed.
+//
+// If either can't be found, the error is a *RedirectError; signedRegion is the region the request
+// was signed for, to explain it.
+func RedirectTarget(resp *http.Response, signedRegion string) (location *url.URL, region string, err error) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	redirectErr := &RedirectError{StatusCode: resp.StatusCode, Location: resp.Header.Get("Location"), Region: signedRegion}
+	location, err = resp.Location()
+	if err != nil {
+		return nil, "", redirectErr
+	}
+	region = resp.Header.Get("x-amz-bucket-region")
+	if region == "" {
+		region = regionFromHost(location.Hostname())
+	}
+	if region == "" {
+		return nil, "", redirectErr
+	}
+	return location, region, nil
+}
+
+// Get the region from an AWS host name, e.g. "us-west-2" from "sqs.us-west-2.amazonaws.com", or from
+// the legacy "us-west-2.queue.amazonaws.com". A global endpoint, e.g. "queue.amazonaws.com", gives
+// sign4.GlobalRegion. Returns "" if the host name has no region, e.g. for "bucket.s3.amazonaws.com", where
+// the label before "amazonaws" is a service name.
+func regionFromHost(host string) string {
+	parts := strings.Split(host, ".")
+	if len(parts) == 3 && parts[1] == "amazonaws" && parts[2] == "com" {
+		return sign4.GlobalRegion
+	}
+	for i := 1; i < len(parts); i++ {
+		if parts[i] == "amazonaws" && i >= 2 {
+			region := parts[i-1]
+			if region == "queue" {
+				region = parts[i-2]
+			}
+			if !regionName.MatchString(region) {
+				return ""
+			}
+			return region
+		}
+	}
+	return ""
+}
+
+// The form of a region name, e.g. "us-west-2", "us-gov-west-1" or "cn-north-1".
+var regionName = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// A redirect that couldn't be followed: there was no usable Location, its region couldn't be found,
+// or it redirected again.
+type RedirectError struct {
+	StatusCode int
+	Location   string
+	Region     string // the region the redirected request was signed for
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("awsclient: request signed for region %v was redirected (%v) to %q, which can't be followed; "+
+		"the resource is probably in another region", e.Region, e.StatusCode, e.Location)
+}
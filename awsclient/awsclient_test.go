@@ -0,0 +1,259 @@
+package awsclient_test
+
+import (
+	"github.com/p-lewis/awsgolang/auth"
+	"github.com/p-lewis/awsgolang/awsclient"
+	"github.com/p-lewis/awsgolang/sign4"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ClientSuite struct {
+	server   *httptest.Server
+	requests []*http.Request // requests received by server
+	bodies   []string        // bodies of the requests
+}
+
+var _ = Suite(&ClientSuite{})
+
+var testCredentials = &auth.Credentials{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+func (s *ClientSuite) SetUpSuite(c *C) {
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, string(body))
+		w.Write([]byte("{}"))
+	}))
+}
+
+func (s *ClientSuite) TearDownSuite(c *C) {
+	s.server.Close()
+}
+
+func (s *ClientSuite) SetUpTest(c *C) {
+	s.requests = nil
+	s.bodies = nil
+}
+
+func (s *ClientSuite) TestDo(c *C) {
+	client := awsclient.New(testCredentials, "us-west-2", "dynamodb", s.server.URL)
+	req, err := client.NewRequest("POST", "/", strings.NewReader(`{"TableName": "Test"}`))
+	c.Assert(err, IsNil)
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810.DescribeTable")
+
+	resp, err := client.Do(req)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	c.Assert(len(s.requests), Equals, 1)
+	authz := s.requests[0].Header.Get("Authorization")
+	c.Assert(authz, Matches, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/[0-9]{8}/us-west-2/dynamodb/aws4_request, .*")
+	c.Assert(strings.Contains(authz, "x-amz-target"), Equals, true)
+	c.Assert(s.requests[0].Header.Get("X-Amz-Date"), Not(Equals), "")
+	c.Assert(s.bodies[0], Equals, `{"TableName": "Test"}`)
+}
+
+func (s *ClientSuite) TestDoHost(c *C) {
+	client := awsclient.New(testCredentials, "us-west-2", "dynamodb", s.server.URL)
+	client.Host = "dynamodb.us-west-2.amazonaws.com"
+	req, err := client.NewRequest("GET", "/", nil)
+	c.Assert(err, IsNil)
+
+	resp, err := client.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+
+	// sent to the endpoint, with the Host header signed
+	c.Assert(len(s.requests), Equals, 1)
+	c.Assert(s.requests[0].Host, Equals, "dynamodb.us-west-2.amazonaws.com")
+	c.Assert(s.requests[0].Header.Get("Authorization"), Matches, ".*SignedHeaders=host;.*")
+}
+
+func (s *ClientSuite) TestServiceNameInScope(c *C) {
+	for _, service := range []string{"sns", "execute-api"} {
+		client := awsclient.New(testCredentials, "us-west-2", service, s.server.URL)
+		req, err := client.NewRequest("GET", "/", nil)
+		c.Assert(err, IsNil)
+		resp, err := client.Do(req)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+	}
+	c.Assert(len(s.requests), Equals, 2)
+	c.Assert(s.requests[0].Header.Get("Authorization"), Matches, ".*/us-west-2/sns/aws4_request, .*")
+	c.Assert(s.requests[1].Header.Get("Authorization"), Matches, ".*/us-west-2/execute-api/aws4_request, .*")
+}
+
+func (s *ClientSuite) TestNoServiceName(c *C) {
+	client := awsclient.New(testCredentials, "us-west-2", "", s.server.URL)
+	req, err := client.NewRequest("GET", "/", nil)
+	c.Assert(err, IsNil)
+	_, err = client.Do(req)
+	c.Assert(err, Equals, awsclient.ErrNoService)
+	c.Assert(len(s.requests), Equals, 0)
+}
+
+func (s *ClientSuite) TestNoRegion(c *C) {
+	client := awsclient.New(testCredentials, "", "sts", s.server.URL)
+	req, err := client.NewRequest("GET", "/", nil)
+	c.Assert(err, IsNil)
+	_, err = client.Do(req)
+	c.Assert(err, Equals, awsclient.ErrNoRegion)
+	c.Assert(len(s.requests), Equals, 0)
+}
+
+func (s *ClientSuite) TestNewRequest(c *C) {
+	client := awsclient.New(testCredentials, "us-east-1", "lambda", "https://lambda.us-east-1.amazonaws.com/")
+	req, err := client.NewRequest("GET", "/2015-03-31/functions/", nil)
+	c.Assert(err, IsNil)
+	c.Assert(req.URL.String(), Equals, "https://lambda.us-east-1.amazonaws.com/2015-03-31/functions/")
+}
+
+func (s *ClientSuite) TestDoFollowsRedirect(c *C) {
+	var authz []string
+	var bodies []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		authz = append(authz, r.Header.Get("Authorization"))
+		bodies = append(bodies, string(body))
+		if r.URL.Path == "/" {
+			w.Header().Set("Location", server.URL+"/moved")
+			w.Header().Set("x-amz-bucket-region", "eu-west-1")
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client := awsclient.New(testCredentials, "us-east-1", "sts", server.URL)
+	req, err := client.NewRequest("POST", "/", strings.NewReader("Action=GetCallerIdentity"))
+	c.Assert(err, IsNil)
+	resp, err := client.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Assert(len(authz), Equals, 2)
+	c.Assert(authz[0], Matches, ".*/us-east-1/sts/aws4_request, .*")
+	c.Assert(authz[1], Matches, ".*/eu-west-1/sts/aws4_request, .*")
+	c.Assert(bodies[1], Equals, "Action=GetCallerIdentity")
+}
+
+func (s *ClientSuite) TestDoRedirectUnknownRegion(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "http://elsewhere.example.com/")
+		w.WriteHeader(http.StatusMovedPermanently)
+	}))
+	defer server.Close()
+
+	client := awsclient.New(testCredentials, "us-east-1", "sts", server.URL)
+	req, err := client.NewRequest("GET", "/", nil)
+	c.Assert(err, IsNil)
+	_, err = client.Do(req)
+	redirectErr, ok := err.(*awsclient.RedirectError)
+	c.Assert(ok, Equals, true)
+	c.Assert(redirectErr.Location, Equals, "http://elsewhere.example.com/")
+	c.Assert(redirectErr.Region, Equals, "us-east-1")
+	c.Assert(err, ErrorMatches, ".*signed for region us-east-1 was redirected \\(301\\).*")
+}
+
+func (s *ClientSuite) TestRedirectTargetRegionFromHost(c *C) {
+	for location, region := range map[string]string{
+		"https://sqs.us-west-2.amazonaws.com/123456789012/TestQueue": "us-west-2",
+		"https://sqs.cn-north-1.amazonaws.com.cn/123456789012/Test":  "cn-north-1",
+		"https://eu-west-1.queue.amazonaws.com/123456789012/Test":    "eu-west-1",
+		"https://sts.ap-east-1.amazonaws.com/":                       "ap-east-1",
+		"https://queue.amazonaws.com/123456789012/Test":              sign4.GlobalRegion,
+		"https://sts.amazonaws.com/":                                 sign4.GlobalRegion,
+	} {
+		resp := &http.Response{StatusCode: http.StatusMovedPermanently, Header: http.Header{"Location": {location}},
+			Body: ioutil.NopCloser(strings.NewReader(""))}
+		url, got, err := awsclient.RedirectTarget(resp, "us-east-1")
+		c.Assert(err, IsNil)
+		c.Assert(url.String(), Equals, location)
+		c.Assert(got, Equals, region)
+	}
+}
+
+func (s *ClientSuite) TestRedirectTargetNoRegionInHost(c *C) {
+	// the label before "amazonaws" is a service, not a region: not followed, rather than signed for "s3"
+	for _, location := range []string{
+		"https://bucket.s3.amazonaws.com/key",
+		"https://bucket.s3-external-1.amazonaws.com/key",
+		"https://elsewhere.example.com/",
+	} {
+		resp := &http.Response{StatusCode: http.StatusMovedPermanently, Header: http.Header{"Location": {location}},
+			Body: ioutil.NopCloser(strings.NewReader(""))}
+		_, _, err := awsclient.RedirectTarget(resp, "us-east-1")
+		redirectErr, ok := err.(*awsclient.RedirectError)
+		c.Assert(ok, Equals, true)
+		c.Assert(redirectErr.Location, Equals, location)
+	}
+
+	// the bucket region header still wins
+	resp := &http.Response{StatusCode: http.StatusMovedPermanently,
+		Header: http.Header{"Location": {"https://bucket.s3.amazonaws.com/key"}, "X-Amz-Bucket-Region": {"eu-west-1"}},
+		Body:   ioutil.NopCloser(strings.NewReader(""))}
+	_, region, err := awsclient.RedirectTarget(resp, "us-east-1")
+	c.Assert(err, IsNil)
+	c.Assert(region, Equals, "eu-west-1")
+}
+
+func (s *ClientSuite) TestBackoffWithinBounds(c *C) {
+	b := awsclient.Backoff{Base: 100 * time.Millisecond, Max: 5 * time.Second}
+	c.Assert(b.Duration(0), Equals, b.Base)
+	for attempt := 0; attempt < 100; attempt++ {
+		for i := 0; i < 100; i++ {
+			d := b.Duration(attempt)
+			c.Assert(d >= b.Base, Equals, true)
+			c.Assert(d <= b.Max, Equals, true)
+		}
+	}
+	c.Assert(awsclient.Backoff{}.Duration(3), Equals, time.Duration(0))
+	c.Assert(awsclient.Backoff{Base: time.Second}.Duration(1000) >= time.Second, Equals, true)
+}
+
+// Each delay is bounded by the previous one, not the attempt number.
+func (s *ClientSuite) TestBackoffNext(c *C) {
+	b := awsclient.Backoff{Base: 100 * time.Millisecond, Max: 5 * time.Second}
+	c.Assert(b.Next(0), Equals, b.Base)
+	for _, prev := range []time.Duration{b.Base, 300 * time.Millisecond, time.Second, b.Max, time.Hour} {
+		upper := 3 * prev
+		if upper > b.Max {
+			upper = b.Max
+		}
+		for i := 0; i < 100; i++ {
+			d := b.Next(prev)
+			c.Assert(d >= b.Base, Equals, true)
+			c.Assert(d <= upper, Equals, true)
+		}
+	}
+	c.Assert(awsclient.Backoff{}.Next(time.Second), Equals, time.Duration(0))
+	c.Assert(awsclient.Backoff{Base: time.Second}.Next(1<<62) >= time.Second, Equals, true)
+}
+
+func (s *ClientSuite) TestBackoffGrows(c *C) {
+	b := awsclient.Backoff{Base: 100 * time.Millisecond, Max: time.Minute}
+	mean := func(attempt int) time.Duration {
+		var total time.Duration
+		for i := 0; i < 1000; i++ {
+			total += b.Duration(attempt)
+		}
+		return total / 1000
+	}
+	last := mean(0)
+	for attempt := 1; attempt < 5; attempt++ {
+		m := mean(attempt)
+		c.Assert(m > last, Equals, true)
+		last = m
+	}
+}
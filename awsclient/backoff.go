@@ -0,0 +1,46 @@
+package awsclient
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Delays between retries, with decorrelated jitter: each delay is random between Base and three times
+// the previous delay, up to Max. A Backoff holds no state, and can be shared; the caller keeps the
+// previous delay (see Next), or counts attempts (see Duration).
+type Backoff struct {
+	Base time.Duration // the shortest delay, and the delay before the first retry
+	Max  time.Duration // the longest delay; 0 for no limit
+}
+
+// Get the delay after a delay of prev, which is 0 before the first retry: Base for the first retry,
+// then random between Base and three times prev, up to Max.
+func (b Backoff) Next(prev time.Duration) time.Duration {
+	if b.Base <= 0 {
+		return 0
+	}
+	if prev <= 0 {
+		return b.Base
+	}
+	upper := prev * 3
+	if prev > (1<<63-1)/3 {
+		upper = 1<<63 - 1
+	}
+	if b.Max > 0 && upper > b.Max {
+		upper = b.Max
+	}
+	if upper <= b.Base {
+		return b.Base
+	}
+	return b.Base + time.Duration(rand.Int63n(int64(upper-b.Base)+1))
+}
+
+// Get a delay before retry number attempt, counting from 0, for a caller that doesn't keep the
+// previous delay: the last of attempt+1 delays from Next, each after the one before.
+func (b Backoff) Duration(attempt int) time.Duration {
+	var delay time.Duration
+	for i := 0; i <= attempt; i++ {
+		delay = b.Next(delay)
+	}
+	return delay
+}
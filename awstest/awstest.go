@@ -0,0 +1,140 @@
+// Helpers for testing code that uses the AWS packages, without AWS.
+//
+// A RecordingTransport captures the signed requests a client sends, in a stable textual form that
+// can be compared with golden files, like the aws4_testsuite files the sign4 tests use.
+package awstest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The line between requests in a golden file.
+const REQUEST_SEPARATOR = "----"
+
+// An http.RoundTripper that records each request it's given, then sends it on with Transport, e.g.
+// for an sqs.SQS built with sqs.NewSQSWithTransport.
+type RecordingTransport struct {
+	// Sends the requests on, e.g. to serve canned responses. If nil, every request gets an empty
+	// 200 OK response.
+	Transport http.RoundTripper
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	t.mu.Lock()
+	t.requests = append(t.requests, FormatRequest(req, body))
+	t.mu.Unlock()
+
+	if t.Transport != nil {
+		return t.Transport.RoundTrip(req)
+	}
+	return &http.Response{Status: "200 OK", StatusCode: http.StatusOK, Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+// The requests recorded so far, as formatted by FormatRequest.
+func (t *RecordingTransport) Requests() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.requests...)
+}
+
+// Forget the requests recorded so far.
+func (t *RecordingTransport) Reset() {
+	t.mu.Lock()
+	t.requests = nil
+	t.mu.Unlock()
+}
+
+var (
+	authzDatePattern      = regexp.MustCompile(`Credential=([^/,]+)/[0-9]{8}/`)
+	authzSignaturePattern = regexp.MustCompile(`Signature=[0-9a-f]+`)
+)
+
+// Format a request, with its body, as text: the method and URL, then the headers sorted by name
+// (the values of each in order), a blank line, and the body.
+//
+// The parts of a signature that change with the time it's made are masked, so the text is the same
+// from one run to the next: the x-amz-date header is "DATE", and in the Authorization header, the
+// date of the credential scope is "DATE" and the signature is "SIGNATURE".
+func FormatRequest(req *http.Request, body []byte) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v %v\n", req.Method, req.URL)
+
+	header := req.Header.Clone()
+	if header.Get("X-Amz-Date") != "" {
+		header.Set("X-Amz-Date", "DATE")
+	}
+	if authz := header.Get("Authorization"); authz != "" {
+		authz = authzDatePattern.ReplaceAllString(authz, "Credential=$1/DATE/")
+		header.Set("Authorization", authzSignaturePattern.ReplaceAllString(authz, "Signature=SIGNATURE"))
+	}
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(&buf, "%v: %v\n", name, value)
+		}
+	}
+	buf.WriteString("\n")
+	buf.Write(body)
+	return buf.String()
+}
+
+// Compare the recorded requests with the golden file at path, which has them separated by
+// REQUEST_SEPARATOR lines. If update is set, the file is (re)written with them instead.
+//
+// Returns an error saying where they differ if they don't match.
+func (t *RecordingTransport) CheckGolden(path string, update bool) error {
+	got := strings.Join(t.Requests(), "\n"+REQUEST_SEPARATOR+"\n")
+	if update {
+		return ioutil.WriteFile(path, []byte(got), 0644)
+	}
+	want, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("awstest: no golden file %v; write it with update set", path)
+	} else if err != nil {
+		return err
+	}
+	if got == string(want) {
+		return nil
+	}
+	gotLines, wantLines := strings.Split(got, "\n"), strings.Split(string(want), "\n")
+	for i := 0; ; i++ {
+		var gotLine, wantLine string
+		if i < len(gotLines) {
+			gotLine = gotLines[i]
+		}
+		if i < len(wantLines) {
+			wantLine = wantLines[i]
+		}
+		if gotLine != wantLine || i >= len(gotLines) || i >= len(wantLines) {
+			return fmt.Errorf("awstest: requests differ from golden file %v at line %v:\n got: %q\nwant: %q",
+				path, i+1, gotLine, wantLine)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package awstest_test
+
+import (
+	"github.com/p-lewis/awsgolang/auth"
+	"github.com/p-lewis/awsgolang/awstest"
+	"github.com/p-lewis/awsgolang/sqs"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type AWSTestSuite struct{}
+
+var _ = Suite(&AWSTestSuite{})
+
+var testCredentials = &auth.Credentials{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+// Responds to every request with a successful SendMessage response.
+type sendMessageTransport struct{}
+
+func (sendMessageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := "<SendMessageResponse><SendMessageResult><MessageId>1</MessageId></SendMessageResult></SendMessageResponse>"
+	return &http.Response{Status: "200 OK", StatusCode: http.StatusOK, Header: http.Header{},
+		Body: ioutil.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+func (s *AWSTestSuite) testQueue(rt http.RoundTripper) *sqs.Queue {
+	return &sqs.Queue{SQS: sqs.NewSQSWithTransport(&sqs.USWest2, testCredentials, rt), Name: "TestQueue",
+		Url: "https://sqs.us-west-2.amazonaws.com/123456789012/TestQueue"}
+}
+
+func (s *AWSTestSuite) TestRecordingTransport(c *C) {
+	recorder := &awstest.RecordingTransport{Transport: sendMessageTransport{}}
+	resp, err := s.testQueue(recorder).SendMessage("hello", &sqs.SendMessageOptions{DelaySeconds: 5})
+	c.Assert(err, IsNil)
+	c.Assert(resp.MessageId, Equals, "1")
+
+	requests := recorder.Requests()
+	c.Assert(len(requests), Equals, 1)
+	c.Assert(requests[0], Equals, "POST https://sqs.us-west-2.amazonaws.com/123456789012/TestQueue/\n"+
+		"Authorization: AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/DATE/us-west-2/sqs/aws4_request, "+
+		"SignedHeaders=content-length;content-type;host;user-agent;x-amz-date, Signature=SIGNATURE\n"+
+		"Content-Type: application/x-www-form-urlencoded\n"+
+		"X-Amz-Date: DATE\n"+
+		"\n"+
+		"AWSAccessKeyId=AKIDEXAMPLE&Action=SendMessage&DelaySeconds=5&MessageBody=hello&Version=2012-11-05")
+
+	recorder.Reset()
+	c.Assert(recorder.Requests(), HasLen, 0)
+}
+
+func (s *AWSTestSuite) TestRecordingTransportDefaultResponse(c *C) {
+	recorder := &awstest.RecordingTransport{}
+	req, err := http.NewRequest("GET", "http://localhost/", nil)
+	c.Assert(err, IsNil)
+	resp, err := recorder.RoundTrip(req)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(recorder.Requests(), DeepEquals, []string{"GET http://localhost/\n\n"})
+}
+
+func (s *AWSTestSuite) TestCheckGolden(c *C) {
+	golden := filepath.Join(c.MkDir(), "send-message.golden")
+	recorder := &awstest.RecordingTransport{Transport: sendMessageTransport{}}
+	queue := s.testQueue(recorder)
+	_, err := queue.SendMessage("hello", nil)
+	c.Assert(err, IsNil)
+	_, err = queue.SendMessage("world", nil)
+	c.Assert(err, IsNil)
+
+	c.Assert(recorder.CheckGolden(golden, false), ErrorMatches, "awstest: no golden file .*")
+	c.Assert(recorder.CheckGolden(golden, true), IsNil)
+	written, err := ioutil.ReadFile(golden)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(written), "\n"+awstest.REQUEST_SEPARATOR+"\n"), Equals, 1)
+
+	// the same requests, signed at another time, match
+	recorder.Reset()
+	_, err = queue.SendMessage("hello", nil)
+	c.Assert(err, IsNil)
+	_, err = queue.SendMessage("world", nil)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.CheckGolden(golden, false), IsNil)
+
+	recorder.Reset()
+	_, err = queue.SendMessage("hello", nil)
+	c.Assert(err, IsNil)
+	_, err = queue.SendMessage("there", nil)
+	c.Assert(err, IsNil)
+	c.Assert(recorder.CheckGolden(golden, false), ErrorMatches,
+		`(?s)awstest: requests differ from golden file .* at line 13:\n got: ".*MessageBody=there.*"\nwant: ".*MessageBody=world.*"`)
+}
@@ -21,6 +21,9 @@ func ExampleReusableRequest_Sign() {
 	t := time.Date(2013, time.October, 31, 10, 30, 0, 0, time.UTC)
 	request.Header.Set("x-amz-date", t.Format(sign4.FMT_AMZN_DATE))
 
+	// The User-Agent is signed too: set it, rather than rely on Go's default, which changes between releases.
+	request.Header.Set("User-Agent", "example-client/1.0")
+
 	//insert your logic for getting credentials here
 	accessKey, secretKey := getCredentials()
 
@@ -35,16 +38,16 @@ func ExampleReusableRequest_Sign() {
 	// Here, we're just going to output the request so we can see the signature.
 	buff := new(bytes.Buffer)
 	httpRequest.Write(buff)
-	fmt.Println(buff.String())
+	fmt.Println(strings.Replace(buff.String(), "\r\n", "\n", -1)) // HTTP lines end in CRLF
 
 	// Output:
 	// POST / HTTP/1.1
 	// Host: service.example.com
-	// User-Agent: Go 1.1 package http
+	// User-Agent: example-client/1.0
 	// Content-Length: 35
-	// Authorization: AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20131031/us-east-1/service/aws4_request, SignedHeaders=content-length;host;user-agent;x-amz-date, Signature=9a0659143c33772a5293374b60b6ade850d8f7c82bdeb657917c7fd3cba86e4d
+	// Authorization: AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20131031/us-east-1/service/aws4_request, SignedHeaders=content-length;host;user-agent;x-amz-date, Signature=d9c9719ae760330f5eb9469527a4d87aaacdeae0bb10d7c42b9a732242037443
 	// X-Amz-Date: 20131031T103000Z
-
+	//
 	// Action=ListUsers&Version=2010-05-08
 }
 
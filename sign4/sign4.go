@@ -5,6 +5,7 @@
 // There are two ways to use the package.
 //
 // 1. Sign: This is the simplified API; just fill in the required parameters to Sign and get a signed  request.
+// A Signer does the same, with some extra options (e.g. the x-amz-content-sha256 header needed by S3).
 //
 // 2. Step-by-Step: If for some reason you need more fine-grained control, you can walk through each step of the signing process. Roughly speaking, this is:
 //
@@ -14,6 +15,13 @@
 //		D. sign the StringToSign (with SignStringToSign)
 //		E. get the AuthHeaderValue
 //		F. add the AuthHeaderValue to the request.Header
+//
+// All times are treated as UTC: the functions taking a time.Time convert it with t.UTC().
+//
+// Case in the canonical request: the method is upper-cased, and header names are lowercased (in the
+// canonical headers and the signed headers). Everything else keeps its case as sent: the path (which
+// is case-sensitive, so "/Photos" and "/photos" are different resources and signatures), the query's
+// names and values, and header values.
 package sign4
 
 import (
@@ -22,11 +30,15 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"github.com/p-lewis/awsgolang/auth"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -35,56 +47,549 @@ import (
 const (
 	FMT_YYYYMMDD  = "20060102"
 	FMT_AMZN_DATE = "20060102T150405Z07:00"
+
+	SCOPE_TERMINATOR = "aws4_request" // the last element of a Signature Version 4 credential scope
+
+	// The region that global services (e.g. IAM, Route 53, CloudFront, and STS's global endpoint) are
+	// signed for, in the standard partition.
+	GlobalRegion = "us-east-1"
 )
 
+// The pseudo-regions that the AWS SDKs use for global endpoints, which aren't signing regions
+// themselves, and the region each is signed for. See SigningRegion.
+var globalSigningRegions = map[string]string{
+	"aws-global":        GlobalRegion,
+	"aws-cn-global":     "cn-north-1",
+	"aws-us-gov-global": "us-gov-west-1",
+}
+
+// Get the region to sign for, given a region name from endpoint or configuration resolution: the
+// real region of a global pseudo-region ("aws-global" gives GlobalRegion, "aws-cn-global" gives
+// "cn-north-1", "aws-us-gov-global" gives "us-gov-west-1"), otherwise the name itself. The signing
+// functions use the region they're given as is, so call this first if a pseudo-region can reach them.
+func SigningRegion(regionName string) string {
+	if signingRegion, ok := globalSigningRegions[regionName]; ok {
+		return signingRegion
+	}
+	return regionName
+}
+
 // Signs a ReusableRequest, and returns a copy of the request as a http.Request for use by http.Client.
 //
 // If the ReusableRequest has either a "Date" or a "x-amz-date" header, that date will be used in the signing
-// process. Otherwise, Sign() will add "x-amz-date" header with the value of the current time (in UTC).
+// process ("Date" if it has both), and "x-amz-date" is rewritten in UTC to match that time. Otherwise, Sign()
+// will add "x-amz-date" header with the value of the current time (in UTC).
+//
+// regionName is signed for as is; for a global service, pass GlobalRegion, or see SigningRegion.
+//
+// Sign uses the default Signer options; use a Signer directly for more control.
 func (req *ReusableRequest) Sign(accessKey, secretKey, regionName, serviceName string) (hreq *http.Request, err error) {
+	signer := &Signer{AccessKey: accessKey, SecretKey: secretKey, Region: regionName, Service: serviceName}
+	return signer.Sign(req)
+}
 
-	var t time.Time
-	// see if we can derive a time from the request
-	if dt := req.Header.Get("Date"); dt != "" {
-		t, err = time.Parse(time.RFC1123, dt)
-		if err != nil {
-			return
-		}
-	} else if dt := req.Header.Get("x-amz-date"); dt != "" {
-		t, err = time.Parse(FMT_AMZN_DATE, dt)
-		if err != nil {
+// Like Sign, but leaves req untouched; the request is cloned (see Clone) and the clone is signed.
+// Use this to sign a template request repeatedly.
+func (req *ReusableRequest) SignCopy(accessKey, secretKey, regionName, serviceName string) (hreq *http.Request, err error) {
+	signer := &Signer{AccessKey: accessKey, SecretKey: secretKey, Region: regionName, Service: serviceName}
+	return signer.SignCopy(req)
+}
+
+// Re-signs a request that was signed before, e.g. after changing a header for a retry. The old
+// "Authorization" and "x-amz-date" headers are removed, the body is rewound, and the request is signed
+// afresh with the current time. A "Date" header, if the request has one, is still used as the signing time.
+func (req *ReusableRequest) Resign(accessKey, secretKey, regionName, serviceName string) (hreq *http.Request, err error) {
+	signer := &Signer{AccessKey: accessKey, SecretKey: secretKey, Region: regionName, Service: serviceName}
+	return signer.Resign(req)
+}
+
+// How the payload (body) hash is used in a signed request. Which mode to use depends on the service.
+type PayloadHashMode int
+
+const (
+	// The payload hash is only used in the canonical request (default). Use this for the query
+	// protocol services (SQS, STS, SNS, ...) and most others; some reject requests that have the
+	// "x-amz-content-sha256" header.
+	PAYLOAD_HASH_CANONICAL_ONLY PayloadHashMode = iota
+	// The payload hash is also sent, and signed, in the "x-amz-content-sha256" header. S3 requires this.
+	PAYLOAD_HASH_HEADER
+	// The payload is not hashed; UNSIGNED-PAYLOAD is used in place of the hash, and sent in the
+	// "x-amz-content-sha256" header. Only S3 accepts this, and only over HTTPS.
+	PAYLOAD_UNSIGNED
+)
+
+const (
+	HDR_CONTENT_SHA256 = "x-amz-content-sha256"
+	UNSIGNED_PAYLOAD   = "UNSIGNED-PAYLOAD"
+)
+
+// Headers that are left out of the signature by default. These are hop-by-hop headers, which
+// proxies and other intermediaries may add, change or remove on the way to AWS.
+var DefaultUnsignedHeaders = []string{"Authorization", "Connection", "Expect", "Keep-Alive",
+	"Proxy-Authenticate", "Proxy-Authorization", "TE", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// A Signer holds the credentials, scope and options used to sign requests.
+type Signer struct {
+	AccessKey, SecretKey string
+	Region, Service      string
+	PayloadHashMode      PayloadHashMode
+
+	// The session token of temporary credentials (e.g. from STS), if any. It's sent, and signed, in
+	// the "x-amz-security-token" header of a signed request, or the X-Amz-Security-Token parameter
+	// of a presigned URL; without it, AWS rejects requests signed with temporary credentials.
+	SessionToken string
+
+	// Headers to leave out of the signature. If nil, DefaultUnsignedHeaders is used. Some services
+	// need extra headers excluded (e.g. "User-Agent"). The "host" and "x-amz-*" headers are always signed.
+	UnsignedHeaders []string
+
+	// Hex encoded SHA256 hash of the body, computed beforehand. If set, it's used in place of hashing
+	// the body, which is then not read while signing (see NewStreamingRequest). Ignored with PAYLOAD_UNSIGNED.
+	PayloadHash string
+
+	// If set, gets the credential scope, canonical request and string to sign of each request signed,
+	// for tracking down signature mismatches. These don't include the secret key, and the session token
+	// is redacted as for an AuditRecord, but they do include the request's other headers.
+	Logger Logger
+
+	// If set, called with an AuditRecord of each request signed or presigned, e.g. for a compliance
+	// log. Unlike Logger's output, a record never has a secret in it.
+	Audit func(AuditRecord)
+}
+
+// What went into signing a request, for an audit log. These are the only fields: the secret key, the
+// signature and any session token are never included.
+type AuditRecord struct {
+	AccessKey       string
+	CredentialScope string
+	SignedHeaders   string // semicolon delimited, as in CanonicalRequestT.Headers
+	// The canonical request, with the value of an x-amz-security-token header or X-Amz-Security-Token
+	// query parameter (a credential) replaced with "REDACTED".
+	CanonicalRequest string
+	Presigned        bool // whether the request was presigned, rather than signed
+}
+
+var (
+	securityTokenHeaderPattern = regexp.MustCompile(`(?m)^(x-amz-security-token:).*$`)
+	securityTokenQueryPattern  = regexp.MustCompile(`(?m)((?:^|&)X-Amz-Security-Token=)[^&\n]*`)
+)
+
+// Call s.Audit, if set, with a record of the canonical request being signed.
+func (s *Signer) audit(cr *CanonicalRequestT, credentialScope string, presigned bool) {
+	if s.Audit == nil {
+		return
+	}
+	s.Audit(AuditRecord{AccessKey: s.AccessKey, CredentialScope: credentialScope, SignedHeaders: cr.Headers,
+		CanonicalRequest: redactSecurityToken(cr.CanonicalRequest), Presigned: presigned})
+}
+
+// Replace the value of the x-amz-security-token header or X-Amz-Security-Token query parameter in a
+// canonical request with "REDACTED", for logging it.
+func redactSecurityToken(canonicalRequest string) string {
+	canonicalRequest = securityTokenHeaderPattern.ReplaceAllString(canonicalRequest, "${1}REDACTED")
+	return securityTokenQueryPattern.ReplaceAllString(canonicalRequest, "${1}REDACTED")
+}
+
+// Errors for signing without keys, which would otherwise only fail at AWS, with SignatureDoesNotMatch
+// or a similarly unhelpful error.
+var (
+	ErrEmptyAccessKey = errors.New("sign4: empty access key")
+	ErrEmptySecretKey = errors.New("sign4: empty secret key")
+)
+
+// Check the Signer has both keys.
+func (s *Signer) checkKeys() error {
+	if s.AccessKey == "" {
+		return ErrEmptyAccessKey
+	}
+	if s.SecretKey == "" {
+		return ErrEmptySecretKey
+	}
+	return nil
+}
+
+// Where a Signer logs to; a *log.Logger will do.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Log to the Signer's Logger, if it has one.
+func (s *Signer) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+// Signs a ReusableRequest, and returns a copy of the request as a http.Request for use by http.Client.
+// See ReusableRequest.Sign for how the signing time is chosen.
+func (s *Signer) Sign(req *ReusableRequest) (hreq *http.Request, err error) {
+	result, err := s.SignWithResult(req)
+	if err != nil {
+		return
+	}
+	return result.Request, nil
+}
+
+// Re-signs a request that was signed before. See ReusableRequest.Resign.
+func (s *Signer) Resign(req *ReusableRequest) (hreq *http.Request, err error) {
+	req.Header.Del("Authorization")
+	req.Header.Del("x-amz-date")
+	if rb, ok := req.Body.(*ReusableBody); ok {
+		if _, err = rb.Seek(0, io.SeekStart); err != nil {
 			return
 		}
-	} else {
+	}
+	return s.Sign(req)
+}
+
+// The outcome of signing a request: the signed request, and the parts of its "Authorization" header.
+type SignResult struct {
+	Request         *http.Request
+	Signature       string // hex encoded, as returned by SignStringToSign
+	SignedHeaders   string // semicolon delimited, as in CanonicalRequestT.Headers
+	CredentialScope string
+}
+
+// Like Sign, but also returns the signature and what went into it.
+func (s *Signer) SignWithResult(req *ReusableRequest) (result *SignResult, err error) {
+	if err = s.checkKeys(); err != nil {
+		return
+	}
+
+	t, err := requestTime(req)
+	if err != nil {
+		return
+	}
+	if t.IsZero() {
 		//set our own date
 		t = time.Now().UTC()
 		req.Header.Set("x-amz-date", t.Format(FMT_AMZN_DATE))
+	} else {
+		// the date headers are signed, so they must agree with the time in the string to sign
+		setRequestTime(req, t)
 	}
 
-	buff := new(bytes.Buffer)
+	// an Authorization header from a previous Sign must not be signed itself
+	req.Header.Del("Authorization")
+	if s.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.SessionToken)
+	}
 
-	err = req.Write(buff)
+	// hash the body as a stream, rather than from the written request, so it isn't buffered
+	payloadHash := UNSIGNED_PAYLOAD
+	if s.PayloadHashMode != PAYLOAD_UNSIGNED && s.PayloadHash != "" {
+		payloadHash = s.PayloadHash
+	} else if s.PayloadHashMode != PAYLOAD_UNSIGNED {
+		payloadHash, err = req.payloadHash()
+		if err != nil {
+			return
+		}
+	}
+	if s.PayloadHashMode != PAYLOAD_HASH_CANONICAL_ONLY {
+		req.Header.Set(HDR_CONTENT_SHA256, payloadHash)
+	}
+
+	buff := new(headerWriter)
+
+	err = req.writeHeaders(buff)
 	if err != nil {
 		return
 	}
 
-	cr, err := CanonicalRequest(buff.String())
+	unsignedHeaders := s.UnsignedHeaders
+	if unsignedHeaders == nil {
+		unsignedHeaders = DefaultUnsignedHeaders
+	}
+	cr, err := canonicalRequest(buff.buf.String(), payloadHash, unsignedHeaders)
 	if err != nil {
 		return
 	}
 
-	credentialScope := CredentialScope(t, regionName, serviceName)
+	credentialScope := CredentialScope(t, s.Region, s.Service)
 	stringToSign := StringToSign(cr.CanonicalRequest, credentialScope, t)
-	signature, err := SignStringToSign(stringToSign, secretKey)
+	s.logf("sign4: credential scope: %s\ncanonical request:\n%s\nstring to sign:\n%s",
+		credentialScope, redactSecurityToken(cr.CanonicalRequest), stringToSign)
+	s.audit(cr, credentialScope, false)
+	signature, err := SignStringToSign(stringToSign, s.SecretKey)
 	if err != nil {
 		return
 	}
 
-	authHeader := AuthHeaderValue(signature, accessKey, credentialScope, cr)
+	authHeader := AuthHeaderValue(signature, s.AccessKey, credentialScope, cr)
 	req.Header.Set("Authorization", authHeader)
 	out := req.ToHttpRequest()
 
-	return &out, nil
+	return &SignResult{Request: &out, Signature: signature, SignedHeaders: cr.Headers, CredentialScope: credentialScope}, nil
+}
+
+// Presigns a request, returning a URL that carries the signature in its query string and is valid for
+// expires (between 1 second and 7 days). Only the host header is signed.
+//
+// The time is taken from the request's "Date" or "x-amz-date" header if it has one, otherwise the
+// current time is used. The CanonicalRequestT that was signed is also returned; if the URL is
+// rejected, compare its QueryString with the URL's query.
+//
+// See http://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func (s *Signer) Presign(req *ReusableRequest, expires time.Duration) (signedUrl string, cr *CanonicalRequestT, err error) {
+	return s.PresignWithHeaders(req, expires, nil)
+}
+
+// Like Presign, but also signs the named headers, with their values from req (which must have them).
+// Whoever uses the URL must then send exactly those headers and values, e.g. the Content-Type of an
+// S3 upload. Headers that aren't signed can be added freely.
+func (s *Signer) PresignWithHeaders(req *ReusableRequest, expires time.Duration, signedHeaders []string) (signedUrl string, cr *CanonicalRequestT, err error) {
+	t, err := requestTime(req)
+	if err != nil {
+		return
+	}
+	if t.IsZero() {
+		t = time.Now().UTC()
+	}
+	return s.presign(req, t, expires, signedHeaders)
+}
+
+// Sign a query protocol request (e.g. for SQS or STS) in its query string, as of time t: the values
+// and the X-Amz-* signing parameters are encoded into the query of endpoint, which is returned, and is
+// valid for expires (between 1 second and 7 days). Only the host header is signed, and the request
+// must have no body. A nil cred is an error wrapping auth.ErrNoAccessKey.
+//
+// This is the signing behind Presign, for when the request is built from values rather than as a
+// ReusableRequest.
+func SignQuery(method, endpoint string, values url.Values, cred *auth.Credentials, region, service string, t time.Time, expires time.Duration) (string, error) {
+	if cred == nil {
+		return "", fmt.Errorf("sign4.SignQuery: No credentials: %w", auth.ErrNoAccessKey)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	for name, vals := range values {
+		query[name] = append(query[name], vals...)
+	}
+	u.RawQuery = query.Encode()
+	req, err := NewReusableRequest(method, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	signer := &Signer{AccessKey: cred.AccessKey, SecretKey: cred.SecretKey, Region: region, Service: service}
+	signedUrl, _, err := signer.presign(req, t, expires, nil)
+	return signedUrl, err
+}
+
+// Presign req as of time t; see PresignWithHeaders.
+func (s *Signer) presign(req *ReusableRequest, t time.Time, expires time.Duration, signedHeaders []string) (signedUrl string, cr *CanonicalRequestT, err error) {
+	if err = s.checkKeys(); err != nil {
+		return
+	}
+	if expires < time.Second || expires > 7*24*time.Hour {
+		return "", nil, fmt.Errorf("Presign expiry must be between 1 second and 7 days, got %v", expires)
+	}
+
+	payloadHash := UNSIGNED_PAYLOAD
+	if s.PayloadHashMode != PAYLOAD_UNSIGNED && s.PayloadHash != "" {
+		payloadHash = s.PayloadHash
+	} else if s.PayloadHashMode != PAYLOAD_UNSIGNED {
+		payloadHash, err = req.payloadHash()
+		if err != nil {
+			return
+		}
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for _, name := range signedHeaders {
+		label := strings.ToLower(name)
+		if label == "host" {
+			continue
+		}
+		values, ok := req.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return "", nil, fmt.Errorf("Presign: header %v is to be signed, but is not set in the request", name)
+		}
+		headers[label] = combineHeaderValues(values)
+	}
+	labels := make([]string, 0, len(headers))
+	for label := range headers {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	headersSigned := strings.Join(labels, ";")
+
+	credentialScope := CredentialScope(t, s.Region, s.Service)
+	query := req.URL.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKey+"/"+credentialScope)
+	query.Set("X-Amz-Date", t.UTC().Format(FMT_AMZN_DATE))
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", headersSigned)
+	if s.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	cr, err = presignedCanonicalRequest(req.Method, req.URL, query, headers, labels, payloadHash)
+	if err != nil {
+		return
+	}
+
+	stringToSign := StringToSign(cr.CanonicalRequest, credentialScope, t)
+	s.logf("sign4: presign credential scope: %s\ncanonical request:\n%s\nstring to sign:\n%s",
+		credentialScope, redactSecurityToken(cr.CanonicalRequest), stringToSign)
+	s.audit(cr, credentialScope, true)
+	signature, err := SignStringToSign(stringToSign, s.SecretKey)
+	if err != nil {
+		return
+	}
+
+	u := *req.URL
+	u.RawQuery = cr.QueryString + "&X-Amz-Signature=" + signature
+	return u.String(), cr, nil
+}
+
+// Build the canonical request of a presigned URL, from its query (with the X-Amz-* parameters, but
+// not X-Amz-Signature) and the signed headers: labels, sorted, and their values.
+func presignedCanonicalRequest(method string, u *url.URL, query url.Values, headers map[string]string, labels []string, payloadHash string) (*CanonicalRequestT, error) {
+	queryString, err := orderAndEncodeUrlValues(query)
+	if err != nil {
+		return nil, err
+	}
+	headersSigned := strings.Join(labels, ";")
+	lines := []string{strings.ToUpper(method), getRawPath(u), queryString}
+	for _, label := range labels {
+		lines = append(lines, label+":"+headers[label])
+	}
+	lines = append(lines, "", headersSigned, payloadHash)
+	return &CanonicalRequestT{strings.Join(lines, "\n"), headersSigned, queryString}, nil
+}
+
+// Errors from VerifyPresignedURL, which wraps them.
+var (
+	ErrPresignedURLInvalid = errors.New("sign4: presigned URL is invalid")
+	ErrPresignedURLExpired = errors.New("sign4: presigned URL has expired")
+	ErrSignatureMismatch   = errors.New("sign4: signature doesn't match")
+)
+
+// Verify a request made with a presigned URL (see Presign), as AWS would: the signature in its query is
+// checked against one computed, with secretKey, for the region and service, from the request, and the
+// URL must not have expired by now. The access key in the URL's credential is the caller's to check
+// (e.g. to look up secretKey), as is the signing time being in the past.
+//
+// The payload is taken to be unsigned (UNSIGNED-PAYLOAD, as for S3) or, for a request without a body,
+// the hash of the empty body, which Presign uses by default.
+func VerifyPresignedURL(req *http.Request, secretKey, region, service string, now time.Time) error {
+	query := req.URL.Query()
+	params := make(map[string]string)
+	for _, name := range []string{"X-Amz-Algorithm", "X-Amz-Credential", "X-Amz-Date", "X-Amz-Expires",
+		"X-Amz-SignedHeaders", "X-Amz-Signature"} {
+		if len(query[name]) != 1 {
+			return fmt.Errorf("%w: need one %v parameter, got %v", ErrPresignedURLInvalid, name, len(query[name]))
+		}
+		params[name] = query.Get(name)
+	}
+	if params["X-Amz-Algorithm"] != "AWS4-HMAC-SHA256" {
+		return fmt.Errorf("%w: unknown algorithm %q", ErrPresignedURLInvalid, params["X-Amz-Algorithm"])
+	}
+	t, err := parseAmzDate(params["X-Amz-Date"])
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPresignedURLInvalid, err)
+	}
+	expires, err := strconv.Atoi(params["X-Amz-Expires"])
+	if err != nil || expires < 1 || expires > 7*24*60*60 {
+		return fmt.Errorf("%w: X-Amz-Expires %q isn't between 1 second and 7 days", ErrPresignedURLInvalid, params["X-Amz-Expires"])
+	}
+	credentialScope := CredentialScope(t, region, service)
+	if !strings.HasSuffix(params["X-Amz-Credential"], "/"+credentialScope) {
+		return fmt.Errorf("%w: credential %q isn't for the scope %v", ErrPresignedURLInvalid, params["X-Amz-Credential"], credentialScope)
+	}
+	if now.After(t.Add(time.Duration(expires) * time.Second)) {
+		return fmt.Errorf("%w: signed at %v, for %v seconds", ErrPresignedURLExpired, t, expires)
+	}
+
+	labels := strings.Split(params["X-Amz-SignedHeaders"], ";")
+	headers := make(map[string]string, len(labels))
+	for _, label := range labels {
+		if label == "host" {
+			headers[label] = req.Host
+			if headers[label] == "" {
+				headers[label] = req.URL.Host
+			}
+			continue
+		}
+		values, ok := req.Header[http.CanonicalHeaderKey(label)]
+		if !ok {
+			return fmt.Errorf("%w: signed header %v is not in the request", ErrSignatureMismatch, label)
+		}
+		headers[label] = combineHeaderValues(values)
+	}
+	if !sort.StringsAreSorted(labels) || headers["host"] == "" {
+		return fmt.Errorf("%w: X-Amz-SignedHeaders %q must be sorted, and include host", ErrPresignedURLInvalid, params["X-Amz-SignedHeaders"])
+	}
+
+	signature := params["X-Amz-Signature"]
+	query.Del("X-Amz-Signature")
+	payloadHashes := []string{UNSIGNED_PAYLOAD}
+	if req.Body == nil || req.Body == http.NoBody {
+		emptyHash, _ := hashSha256Body(nil)
+		payloadHashes = append(payloadHashes, emptyHash)
+	}
+	for _, payloadHash := range payloadHashes {
+		cr, err := presignedCanonicalRequest(req.Method, req.URL, query, headers, labels, payloadHash)
+		if err != nil {
+			return err
+		}
+		expect, err := SignStringToSign(StringToSign(cr.CanonicalRequest, credentialScope, t), secretKey)
+		if err != nil {
+			return err
+		}
+		if hmac.Equal([]byte(signature), []byte(expect)) {
+			return nil
+		}
+	}
+	return ErrSignatureMismatch
+}
+
+// Get the signing time from the request's "Date" or "x-amz-date" header. Returns the zero time if
+// the request has neither header.
+func requestTime(req *ReusableRequest) (t time.Time, err error) {
+	if dt := req.Header.Get("Date"); dt != "" {
+		return time.Parse(time.RFC1123, dt)
+	} else if dt := req.Header.Get("x-amz-date"); dt != "" {
+		return parseAmzDate(dt)
+	}
+	return
+}
+
+// The layouts an "x-amz-date" header is parsed with: FMT_AMZN_DATE takes "Z" and "+00:00"; the other
+// takes an offset without the colon, e.g. "+0000".
+var amzDateLayouts = []string{FMT_AMZN_DATE, "20060102T150405Z0700"}
+
+// Parse an "x-amz-date" value, in any of amzDateLayouts, as a time in UTC.
+func parseAmzDate(value string) (t time.Time, err error) {
+	for _, layout := range amzDateLayouts {
+		if t, err = time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("sign4: can't parse x-amz-date %q: %w", value, err)
+}
+
+// Rewrite the request's "x-amz-date" header, if it has one, to be the time t (in UTC). The "Date"
+// header, when there is one, is left as is: t is taken from it.
+func setRequestTime(req *ReusableRequest, t time.Time) {
+	if req.Header.Get("x-amz-date") != "" {
+		req.Header.Set("x-amz-date", t.UTC().Format(FMT_AMZN_DATE))
+	}
+}
+
+// Like Sign, but leaves req untouched; the request is cloned (see ReusableRequest.Clone) and the clone is signed.
+func (s *Signer) SignCopy(req *ReusableRequest) (hreq *http.Request, err error) {
+	clone, err := req.Clone()
+	if err != nil {
+		return
+	}
+	return s.Sign(clone)
 }
 
 // Get the finalized value for the "Authorization" header. The signature parameter is the output from SignStringToSign
@@ -96,12 +601,19 @@ func AuthHeaderValue(signature, accessKey, credentialScope string, cr *Canonical
 type CanonicalRequestT struct {
 	CanonicalRequest string
 	Headers          string // semicolon delimited list of the headers in the canonical request
+	QueryString      string // the canonical query string (sorted and encoded), as used in CanonicalRequest
 }
 
-// Build a CanonicalRequestT from a regular request string
+// Build a CanonicalRequestT from a regular request string. The DefaultUnsignedHeaders are left out.
 //
 // See http://docs.aws.amazon.com/general/latest/gr/sigv4-create-canonical-request.html
 func CanonicalRequest(req string) (cr *CanonicalRequestT, err error) {
+	return canonicalRequest(req, "", DefaultUnsignedHeaders)
+}
+
+// Build a CanonicalRequestT, using payloadHash in place of the body hash if it is not empty, and
+// leaving out the unsignedHeaders.
+func canonicalRequest(req string, payloadHash string, unsignedHeaders []string) (cr *CanonicalRequestT, err error) {
 
 	lines := strings.Split(req, "\r\n")
 
@@ -122,7 +634,7 @@ func CanonicalRequest(req string) (cr *CanonicalRequestT, err error) {
 	}
 
 	out[0] = strings.ToUpper(line1parts[0])
-	out[1] = getRawPath(line1parts[1])
+	out[1] = getRawPath(reqUrl)
 	out[2], err = orderAndEncodeUrlValues(reqUrl.Query())
 
 	if err != nil {
@@ -130,7 +642,7 @@ func CanonicalRequest(req string) (cr *CanonicalRequestT, err error) {
 	}
 
 	// work on the headers
-	hmap, sortedKeys := crHeaderMap(lines)
+	hmap, sortedKeys := crHeaderMap(lines, unsignedHeaders)
 	sgnHeaders := make([]string, 0, len(sortedKeys))
 	for _, hkey := range sortedKeys {
 		hval := hmap[hkey]
@@ -142,28 +654,106 @@ func CanonicalRequest(req string) (cr *CanonicalRequestT, err error) {
 	out = append(out, "\n"+headersSigned)
 
 	// work on body
-	bbody := getBody(lines)
+	if payloadHash == "" {
+		payloadHash, err = hashSha256Body(getBody(lines))
+		if err != nil {
+			return
+		}
+	}
+	out = append(out, payloadHash)
 
-	hashStr, err := hashSha256Body(bbody)
+	cr = &CanonicalRequestT{strings.Join(out, "\n"), headersSigned, out[2]}
+
+	return
+}
+
+// Build a CanonicalRequestT directly from an http.Request, without writing it out as a string. The
+// DefaultUnsignedHeaders are left out.
+//
+// The headers are those in req.Header, plus host (from req.Host, or else req.URL) and, if
+// req.ContentLength is positive, content-length. Headers that Go adds when sending (e.g. a default
+// User-Agent) aren't signed, which AWS accepts.
+//
+// If payloadHash is empty, the body is hashed: it must be a body that can be read again, i.e. a
+// ReusableBody, or one req.GetBody can recreate (as http.NewRequest sets up for bytes and strings
+// readers). Either way req.Body is left as it was.
+func CanonicalRequestFromHTTP(req *http.Request, payloadHash string) (cr *CanonicalRequestT, err error) {
+	queryString, err := orderAndEncodeUrlValues(req.URL.Query())
 	if err != nil {
 		return
 	}
-	out = append(out, hashStr)
+	out := []string{strings.ToUpper(req.Method), getRawPath(req.URL), queryString}
+
+	skip := unsignedHeaderSet(DefaultUnsignedHeaders)
+	headers := map[string]string{}
+	for name, values := range req.Header {
+		label := strings.ToLower(name)
+		if skip[label] || label == "host" {
+			continue
+		}
+		headers[label] = combineHeaderValues(values)
+	}
+	headers["host"] = req.Host
+	if req.Host == "" {
+		headers["host"] = req.URL.Host
+	}
+	if req.ContentLength > 0 {
+		headers["content-length"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	labels := make([]string, 0, len(headers))
+	for label := range headers {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		out = append(out, label+":"+headers[label])
+	}
+	headersSigned := strings.Join(labels, ";")
+	out = append(out, "\n"+headersSigned)
 
-	cr = &CanonicalRequestT{strings.Join(out, "\n"), headersSigned}
+	if payloadHash == "" {
+		payloadHash, err = httpPayloadHash(req)
+		if err != nil {
+			return
+		}
+	}
+	out = append(out, payloadHash)
 
-	return
+	return &CanonicalRequestT{strings.Join(out, "\n"), headersSigned, queryString}, nil
 }
 
-func getRawPath(rawUrl string) string {
-	// We can't use the norman URL functionality, because we need the raw unencoded path for
-	// the canonical request, and URL.Path encodes things for us.
+// Hex encoded SHA256 hash of an http.Request's body, read from a fresh copy so req.Body is untouched.
+func httpPayloadHash(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return hashSha256Body(nil)
+	}
+	if _, ok := req.Body.(*ReusableBody); ok {
+		return (&ReusableRequest{req}).payloadHash()
+	}
+	if req.GetBody == nil {
+		return "", errors.New("sign4: the request body can't be read again to hash it; pass its payload hash")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, body); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
 
-	if rawUrl == "/" {
+// Get the canonical URI of a URL: its path, normalized (see path.Clean, though a trailing "/" is
+// kept), but otherwise as sent. In particular, its case is kept.
+func getRawPath(u *url.URL) string {
+	// The path is taken escaped, as sent, and apart from the query, so nothing in the query (e.g. an
+	// encoded "?" or "/") can be mistaken for part of it.
+	urlPath := u.EscapedPath()
+	if urlPath == "" || urlPath == "/" {
 		return "/"
 	}
-	parts := strings.SplitN(rawUrl, "?", 2)
-	urlPath := parts[0]
 
 	cleaned := path.Clean(urlPath)
 	// Clean doesn't add the trailing slash, so add back if in the original path
@@ -188,14 +778,26 @@ func getBody(reqLines []string) (body []byte) {
 	return
 }
 
+// Percent encode a query key or value as Signature Version 4 does. This is url.QueryEscape, except
+// that a space is "%20" rather than "+" (QueryEscape encodes a literal "+" as "%2B").
+func uriEncode(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// Get the canonical query string for values: sorted by name then value, and encoded as Signature
+// Version 4 does. Sending it as a request's query makes what's sent the same, byte for byte, as what
+// is signed.
+func CanonicalQueryString(values url.Values) (string, error) {
+	return orderAndEncodeUrlValues(values)
+}
+
 func orderAndEncodeUrlValues(values url.Values) (string, error) {
 
-	//fmt.Println("values:", values)
 	keys := make([]string, len(values))
 	out := make([]string, 0, len(values))
 	i := 0
 	for k, _ := range values {
-		keys[i] = url.QueryEscape(k)
+		keys[i] = uriEncode(k)
 		i++
 	}
 
@@ -209,45 +811,105 @@ func orderAndEncodeUrlValues(values url.Values) (string, error) {
 		vals := values[original_k]
 		sort.Strings(vals)
 		for _, dupVal := range vals {
-			out = append(out, fmt.Sprintf("%v=%v", k, url.QueryEscape(dupVal)))
+			out = append(out, fmt.Sprintf("%v=%v", k, uriEncode(dupVal)))
 		}
 	}
 
 	return strings.Join(out, "&"), nil
 }
 
-// make a Canonical Request map of the headers
-func crHeaderMap(lines []string) (headers map[string]string, sortedKeys []string) {
+// make a Canonical Request map of the headers, leaving out the unsignedHeaders (except for host and x-amz-*)
+func crHeaderMap(lines []string, unsignedHeaders []string) (headers map[string]string, sortedKeys []string) {
 	sortedKeys = make([]string, 0, len(lines))
 	headers = make(map[string]string)
 
-	//fmt.Printf("sortedKeys: %v, len: %v cap: %v\n", sortedKeys, len(sortedKeys), cap(sortedKeys))
-	for _, line := range lines[1:] {
-		if line == "" {
-			break
-		}
+	skip := unsignedHeaderSet(unsignedHeaders)
+
+	values := make(map[string][]string)
+	for _, line := range unfoldHeaders(lines[1:]) {
 		splitline := strings.SplitN(line, ":", 2)
 		if len(splitline) == 2 {
 			label := strings.ToLower(splitline[0])
-			value := trimAll(splitline[1])
-			if current, ok := headers[label]; ok {
-				headers[label] = current + "," + value
-			} else {
-				headers[label] = value
+			if skip[label] {
+				continue
+			}
+			if _, ok := values[label]; !ok {
 				sortedKeys = append(sortedKeys, label)
 			}
+			values[label] = append(values[label], splitline[1])
 		}
 	}
+	for label, v := range values {
+		headers[label] = combineHeaderValues(v)
+	}
 	sort.Strings(sortedKeys)
 	return headers, sortedKeys
 }
 
-// Return the Credential Scope. See http://docs.aws.amazon.com/general/latest/gr/sigv4-create-string-to-sign.html
+// Combine the values of a header that appears more than once: they're joined with commas in the order
+// they appear in the request (not sorted), and the whole value is then trimmed with trimAll.
+func combineHeaderValues(values []string) string {
+	stripped := make([]string, len(values))
+	for i, v := range values {
+		stripped[i] = strings.TrimSpace(v) // the whitespace around a value isn't part of it
+	}
+	return trimAll(strings.Join(stripped, ","))
+}
+
+// Get the lower case labels of the unsignedHeaders that may be left out: host and x-amz-* must be signed.
+func unsignedHeaderSet(unsignedHeaders []string) map[string]bool {
+	skip := make(map[string]bool, len(unsignedHeaders))
+	for _, h := range unsignedHeaders {
+		label := strings.ToLower(h)
+		if label != "host" && !strings.HasPrefix(label, "x-amz-") {
+			skip[label] = true
+		}
+	}
+	return skip
+}
+
+// Get the header lines (up to the first blank line), with any folded (continuation) lines, which
+// start with whitespace, joined on to the header line before them.
+func unfoldHeaders(lines []string) []string {
+	unfolded := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(unfolded) > 0 {
+			unfolded[len(unfolded)-1] += " " + line
+			continue
+		}
+		unfolded = append(unfolded, line)
+	}
+	return unfolded
+}
+
+// Check that t is usable as a signing time as is: not zero, and in UTC. The functions in this package
+// convert times to UTC themselves; this is for times formatted elsewhere, e.g. the dateStamp for
+// SigningKey, as near midnight a local time's date differs from the UTC date in the credential scope.
+func ValidateSigningTime(t time.Time) error {
+	if t.IsZero() {
+		return errors.New("sign4: signing time is zero")
+	}
+	if _, offset := t.Zone(); offset != 0 {
+		return fmt.Errorf("sign4: signing time %v is not in UTC; use t.UTC()", t)
+	}
+	return nil
+}
+
+// Return the Credential Scope, with the date of t in UTC. See http://docs.aws.amazon.com/general/latest/gr/sigv4-create-string-to-sign.html
+// regionName is used as is, whatever it is; see SigningRegion for the global pseudo-regions.
 func CredentialScope(t time.Time, regionName, serviceName string) string {
-	return fmt.Sprintf("%s/%s/%s/aws4_request", t.UTC().Format(FMT_YYYYMMDD), regionName, serviceName)
+	return CredentialScopeWithTerminator(t, regionName, serviceName, SCOPE_TERMINATOR)
+}
+
+// Like CredentialScope, but ending in terminator rather than SCOPE_TERMINATOR, for signing variants.
+func CredentialScopeWithTerminator(t time.Time, regionName, serviceName, terminator string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", t.UTC().Format(FMT_YYYYMMDD), regionName, serviceName, terminator)
 }
 
-// Create a "String to Sign". See http://docs.aws.amazon.com/general/latest/gr/sigv4-create-string-to-sign.html
+// Create a "String to Sign", with t in UTC. See http://docs.aws.amazon.com/general/latest/gr/sigv4-create-string-to-sign.html
 func StringToSign(canonicalRequest, credentialScope string, t time.Time) string {
 	hash := sha256.New()
 	hash.Write([]byte(canonicalRequest))
@@ -258,6 +920,9 @@ func StringToSign(canonicalRequest, credentialScope string, t time.Time) string
 
 // Create the AWS Signature Version 4. See http://docs.aws.amazon.com/general/latest/gr/sigv4-calculate-signature.html
 func SignStringToSign(sts, secretKey string) (string, error) {
+	if secretKey == "" {
+		return "", ErrEmptySecretKey
+	}
 
 	lines := strings.Split(sts, "\n")
 	if len(lines) != 4 {
@@ -268,9 +933,9 @@ func SignStringToSign(sts, secretKey string) (string, error) {
 		return "", fmt.Errorf("Expected 4 elements in: %v", lines[2])
 	}
 
-	dateStamp, region, service := parts[0], parts[1], parts[2]
+	dateStamp, region, service, terminator := parts[0], parts[1], parts[2], parts[3]
 
-	sk, err := SigningKey(secretKey, dateStamp, region, service)
+	sk, err := SigningKeyWithTerminator(secretKey, dateStamp, region, service, terminator)
 	if err != nil {
 		return "", err
 	}
@@ -284,19 +949,25 @@ func SignStringToSign(sts, secretKey string) (string, error) {
 
 }
 
-// Generate a "signing key" to sign the "String To Sign". See http://docs.aws.amazon.com/general/latest/gr/sigv4-calculate-signature.html
+// Generate a "signing key" to sign the "String To Sign". The dateStamp (FMT_YYYYMMDD) must be the UTC date, as
+// in the credential scope. See http://docs.aws.amazon.com/general/latest/gr/sigv4-calculate-signature.html
 func SigningKey(awsKey, dateStamp, regionName, serviceName string) ([]byte, error) {
+	return SigningKeyWithTerminator(awsKey, dateStamp, regionName, serviceName, SCOPE_TERMINATOR)
+}
+
+// Like SigningKey, but derived with terminator rather than SCOPE_TERMINATOR, to match a credential
+// scope from CredentialScopeWithTerminator.
+func SigningKeyWithTerminator(awsKey, dateStamp, regionName, serviceName, terminator string) ([]byte, error) {
 
 	key := []byte("AWS4" + awsKey)
 	var err error
 
-	data := []string{dateStamp, regionName, serviceName, "aws4_request"}
+	data := []string{dateStamp, regionName, serviceName, terminator}
 	for _, d := range data {
 		key, err = signHMAC(key, d)
 		if err != nil {
 			return nil, err
 		}
-		//fmt.Printf("key: %x\n", key)
 	}
 
 	return key, nil
@@ -314,7 +985,6 @@ func signHMAC(key []byte, data string) ([]byte, error) {
 
 func hashSha256Body(body []byte) (string, error) {
 	hash := sha256.New()
-	//fmt.Printf("body: %v\n", body)
 	if body == nil {
 		_, err := hash.Write([]byte(""))
 		if err != nil {
@@ -355,8 +1025,12 @@ func trimAll(val string) string {
 	return buffer.String()
 }
 
+// A request body that can be read again after seeking back to the start.
+//
+// Any io.ReadSeeker (e.g. a *bytes.Reader or an *os.File) can be used, and is read directly rather than
+// copied into memory. The ReadSeeker is not closed by Close; close files yourself once the request is done.
 type ReusableBody struct {
-	*bytes.Reader
+	io.ReadSeeker
 }
 
 // This is a noop function used to satisfy the io.ReadCloser interface.
@@ -364,6 +1038,16 @@ func (b ReusableBody) Close() error {
 	return nil // noop
 }
 
+// Size of the body in bytes, found by seeking to the end. The body is left at the start.
+func (b ReusableBody) size() (int64, error) {
+	end, err := b.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = b.Seek(0, io.SeekStart)
+	return end, err
+}
+
 // Request where the Body can be reused and reset. This type wraps http.Request.
 // This type is used in the signing process because we need to read the request Body,
 // and an http.Request is normally only avaliable to read once, making it unusable
@@ -377,7 +1061,8 @@ type ReusableRequest struct {
 
 // Create a new ReusableRequest.
 //
-// Warning: will read the body, possibly consuming it.
+// Warning: will read the body, possibly consuming it. An io.ReadSeeker body is used as is (so it is not
+// copied into memory), and is read from its start.
 func NewReusableRequest(method, urlString string, body io.Reader) (*ReusableRequest, error) {
 	req, err := http.NewRequest(method, urlString, nil)
 	if err != nil {
@@ -390,7 +1075,30 @@ func NewReusableRequest(method, urlString string, body io.Reader) (*ReusableRequ
 			return nil, err
 		}
 		req.Body = rb
-		req.ContentLength = int64(rb.Len())
+		req.ContentLength, err = rb.size()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ReusableRequest{req}, nil
+}
+
+// Build a request whose body is streamed from an io.Reader of known size, without buffering it. As the body
+// can only be read once, it isn't hashed: sign the request with a Signer using PAYLOAD_UNSIGNED, or with
+// the PayloadHash set. The request can then be sent once.
+//
+// contentLength is sent (and signed) as the Content-Length, and must be the exact length of body.
+func NewStreamingRequest(method, urlString string, body io.Reader, contentLength int64) (*ReusableRequest, error) {
+	if contentLength < 0 {
+		return nil, fmt.Errorf("sign4.NewStreamingRequest: contentLength must not be negative, got %v", contentLength)
+	}
+	req, err := http.NewRequest(method, urlString, nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(body)
+		req.ContentLength = contentLength
 	}
 	return &ReusableRequest{req}, nil
 }
@@ -407,8 +1115,7 @@ func NewReusableRequestFromRequest(req *http.Request) (*ReusableRequest, error)
 			return nil, err
 		}
 		// copy the body
-		b := make([]byte, rb.Len())
-		_, err = rb.Read(b)
+		b, err := ioutil.ReadAll(rb)
 		rb.Seek(0, 0)
 		if err != nil {
 			return nil, err
@@ -420,7 +1127,29 @@ func NewReusableRequestFromRequest(req *http.Request) (*ReusableRequest, error)
 	return rreq, nil
 }
 
-// Convert a ReusableRequest to a http.Request
+// Make an independent copy of the request. The headers and body are copied, so changes to
+// the copy (like those made by Sign) don't affect the original.
+func (req *ReusableRequest) Clone() (*ReusableRequest, error) {
+	clone := &ReusableRequest{req.Request.Clone(req.Context())}
+	if req.Body != nil {
+		rb, ok := req.Body.(*ReusableBody)
+		if !ok {
+			return nil, errors.New("Not sure body can be reused (did req.Body get changed?)")
+		}
+		rb.Seek(0, 0)
+		b, err := ioutil.ReadAll(rb)
+		rb.Seek(0, 0)
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = &ReusableBody{bytes.NewReader(b)}
+	}
+	return clone, nil
+}
+
+// Convert a ReusableRequest to a http.Request. With a ReusableBody, GetBody is set to rewind it, so
+// http.Client can send the body again, e.g. after a 307 or 308 redirect. The body it returns is the
+// same ReusableBody, not a copy.
 func (req *ReusableRequest) ToHttpRequest() (hreq http.Request) {
 	hreq.Method = req.Method
 	hreq.URL = req.URL
@@ -429,6 +1158,14 @@ func (req *ReusableRequest) ToHttpRequest() (hreq http.Request) {
 	hreq.ProtoMinor = req.ProtoMinor
 	hreq.Header = req.Header
 	hreq.Body = req.Body
+	if rb, ok := req.Body.(*ReusableBody); ok {
+		hreq.GetBody = func() (io.ReadCloser, error) {
+			if _, err := rb.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return rb, nil
+		}
+	}
 	hreq.ContentLength = req.ContentLength
 	hreq.TransferEncoding = req.TransferEncoding
 	hreq.Close = req.Close
@@ -445,13 +1182,12 @@ func (req *ReusableRequest) ToHttpRequest() (hreq http.Request) {
 
 func makeReusableBody(body io.Reader) (rb *ReusableBody, err error) {
 
-	//fmt.Printf("Type of body: %T\n", body)
 	if body != nil {
 		switch v := body.(type) {
-		case *bytes.Reader:
-			rb = &ReusableBody{v}
 		case *ReusableBody:
 			rb = v
+		case io.ReadSeeker:
+			rb = &ReusableBody{v}
 		default:
 			buf := new(bytes.Buffer)
 			_, err := buf.ReadFrom(body)
@@ -467,6 +1203,66 @@ func makeReusableBody(body io.Reader) (rb *ReusableBody, err error) {
 	return rb, nil
 }
 
+// An io.Writer that keeps the header section (up to and including the first blank line) of a
+// written request, and discards the body.
+type headerWriter struct {
+	buf  bytes.Buffer
+	done bool
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if !w.done {
+		w.buf.Write(p)
+		if i := bytes.Index(w.buf.Bytes(), []byte("\r\n\r\n")); i >= 0 {
+			w.buf.Truncate(i + 4)
+			w.done = true
+		}
+	}
+	return len(p), nil
+}
+
+// Write the request to a headerWriter without reading the body, which the headerWriter would
+// discard anyway: ContentLength placeholder bytes are written in its place. So signing reads the body
+// only once, to hash it.
+func (req *ReusableRequest) writeHeaders(w *headerWriter) error {
+	if req.Body == nil || req.ContentLength < 0 {
+		return req.Write(w)
+	}
+	body := req.Body
+	defer func() { req.Body = body }()
+	req.Body = ioutil.NopCloser(io.LimitReader(placeholderReader{}, req.ContentLength))
+	return req.Request.Write(w)
+}
+
+// An io.Reader that never ends, and leaves the buffer as it is: the bytes read are meaningless.
+type placeholderReader struct{}
+
+func (placeholderReader) Read(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Hex encoded SHA256 hash of the request body.
+func (req *ReusableRequest) payloadHash() (string, error) {
+	if req.Body == nil {
+		return hashSha256Body(nil)
+	}
+	rb, ok := req.Body.(*ReusableBody)
+	if !ok {
+		return "", errors.New("Not sure body can be reused (did req.Body get changed?)")
+	}
+	// hash the whole body, even if some of it was read already
+	if _, err := rb.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	defer rb.Seek(0, io.SeekStart)
+	hash := sha256.New()
+	_, err := io.Copy(hash, rb)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
 func (req *ReusableRequest) Write(w io.Writer) error {
 	return req.write(w, false)
 }
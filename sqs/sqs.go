@@ -1,28 +1,93 @@
 package sqs
 
 import (
-	//"bytes"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/p-lewis/awsgolang/auth"
+	"github.com/p-lewis/awsgolang/awsclient"
 	"github.com/p-lewis/awsgolang/sign4"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	AWS_API_VERSION = "2012-11-05"
-	SERVICE_NAME    = "sqs"
+	AWS_API_VERSION       = "2012-11-05"
+	SERVICE_NAME          = "sqs"
+	MAX_BATCH_ENTRIES     = 10                     // maximum number of entries in a batch request
+	MAX_WAIT_TIME_SECONDS = 20                     // longest a ReceiveMessage call can long poll for
+	MAX_DELAY_SECONDS     = 900                    // longest a message's delivery can be delayed for
+	DRAIN_WAIT_SECONDS    = 1                      // long poll wait of each receive by Drain
+	DRAIN_EMPTY_RECEIVES  = 2                      // empty receives in a row after which Drain stops
+	RETRY_BASE_DELAY      = 100 * time.Millisecond // delay before the first retry; see retryBackoff
+	MAX_RETRY_DELAY       = 5 * time.Second        // longest delay before a retry
+	MAX_PARALLEL_DELETES  = 5                      // maximum number of queues DeleteQueuesByPrefix deletes at once
+	MAX_PARALLEL_TAG_GETS = 5                      // maximum number of queues ListQueuesByTag gets the tags of at once
+
+	DEFAULT_MAX_RESPONSE_BYTES = 64 << 20  // response body size limit if SQS.MaxResponseBytes isn't set
+	MAX_ERROR_BODY_BYTES       = 256       // most of an unrecognized response body quoted in an error
+	FIFO_SUFFIX                = ".fifo"   // the end of every FIFO queue's name
+	MAX_QUEUE_NAME_LENGTH      = 80        // including the FIFO_SUFFIX of a FIFO queue
+	DLQ_SUFFIX                 = "-dlq"    // added to a queue's name for its dead-letter queue's, by CreateQueueWithDLQ
+	MAX_RECEIVE_COUNT          = 1000      // the most receives a redrive policy can allow before moving a message
+	MAX_BATCH_BYTES            = 256 << 10 // total size of the messages, with their attributes, in a batch
 )
 
 // The SQS type encapsulates operations with an SQS region.
+//
+// An SQS value must not be copied after first use, as it caches the http.Client built by ClientFactory.
 type SQS struct {
 	Credentials   *auth.Credentials
 	Region        *Region
-	ClientFactory func() *http.Client // Factory function that builds an http.Client for requests
+	ClientFactory func() *http.Client // Factory function that builds the http.Client for requests; called once, on first use
+	Debug         bool                // If set, RawResponse is filled in for all responses, including streamed ones
+	MaxRetries    int                 // Times to retry a request after a network error or server fault (see ErrorResponse.IsServerError); 0 never retries. See WithMaxRetries
+	// Largest response body (after decompression) read before giving up with ErrResponseTooLarge;
+	// 0 uses DEFAULT_MAX_RESPONSE_BYTES. A guard against misbehaving endpoints, e.g. test servers.
+	MaxResponseBytes int64
+	// If set, gets the canonical request and string to sign of each request as it's signed; see sign4.Signer.Logger
+	Logger sign4.Logger
+	// If set, attribute names that aren't in QueueAttributeNames are sent to SQS as is, rather than
+	// rejected; for attributes newer than this package.
+	AllowUnknownAttributes bool
+	// Builds the error that an error response is unmarshalled to, for a service or SQS-compatible
+	// server with its own error shape (e.g. <Response><Errors><Error>); if nil, *ErrorResponse is used.
+	NewErrorResponse func() BodyUnmarshallerError
+	// If set, requests are built and signed, but not sent: each action fails with ErrDryRun, and the
+	// request is kept for LastRequest. For testing the parameters and signing of actions.
+	DryRun bool
+	// If set, the Host header sent, and signed, in place of the host of the endpoint or queue URL; the
+	// connection is still made to that host (e.g. an IP address). For load balancers and test servers
+	// that route on, or check, the Host header. A redirect's location replaces it.
+	Host string
+	// Gets the current time, e.g. for SendMessageAt to work out a delay from; if nil, time.Now is used.
+	// For testing.
+	Now func() time.Time
+
+	clientOnce sync.Once
+	client     *http.Client
+
+	lastRequestMu sync.Mutex
+	lastRequest   *http.Request
 }
 
 // The queue type encapsulates operations with an SQS Queue.
@@ -32,19 +97,150 @@ type Queue struct {
 	Url  string
 }
 
+// The queue's name and URL; the SQS, with its credentials, is left out so it can't end up in logs.
+func (q *Queue) String() string {
+	return fmt.Sprintf("Queue{Name: %v, Url: %v}", q.Name, q.Url)
+}
+
+// Whether the queue is a FIFO queue, i.e. its name ends in FIFO_SUFFIX.
+func (q *Queue) IsFifo() bool {
+	return strings.HasSuffix(q.Name, FIFO_SUFFIX)
+}
+
+// Whether other is the same queue, i.e. has the same name and URL.
+func (q *Queue) Equal(other *Queue) bool {
+	if q == nil || other == nil {
+		return q == other
+	}
+	return q.Name == other.Name && q.Url == other.Url
+}
+
 type Region struct {
 	Name     string // the canonical name of this region.
 	Endpoint string // URL for the endpoint of this region
 }
 
+// Returned for a request if the SQS's Region has no Name. There is no default region; use USEast (or
+// Legacy, for the global endpoint) explicitly.
+var ErrNoRegion = errors.New("sqs: region has no name")
+
+// Returned by every action when SQS.DryRun is set, after the request is signed.
+var ErrDryRun = errors.New("sqs: dry run, request not sent")
+
+// Delays between the retries of a request, from RETRY_BASE_DELAY up to MAX_RETRY_DELAY.
+var retryBackoff = awsclient.Backoff{Base: RETRY_BASE_DELAY, Max: MAX_RETRY_DELAY}
+
 func DefaultClientFactory() *http.Client {
 	return http.DefaultClient
 }
 
+// Connection pooling options for NewClientFactory. A zero field takes its value from
+// DefaultTransportOptions.
+type TransportOptions struct {
+	// Maximum idle (keep-alive) connections kept, across all hosts.
+	MaxIdleConns int
+	// Maximum idle connections kept per host. An SQS sends all its requests to one host, so this
+	// should be at least the number of requests sent at once; the net/http default is only 2.
+	MaxIdleConnsPerHost int
+	// How long an idle connection is kept before it's closed.
+	IdleConnTimeout time.Duration
+	// If set, only HTTP/1.1 is used. Otherwise HTTP/2 is used when the endpoint supports it, which
+	// sends concurrent requests over one connection.
+	DisableHTTP2 bool
+}
+
+// Defaults for TransportOptions, sized for sending around a hundred requests at once.
+var DefaultTransportOptions = TransportOptions{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 100,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// Build a ClientFactory whose http.Client has a transport tuned with opts. Each http.Client it
+// builds has its own transport, and so its own connection pool.
+func NewClientFactory(opts TransportOptions) func() *http.Client {
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = DefaultTransportOptions.MaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost == 0 {
+		opts.MaxIdleConnsPerHost = DefaultTransportOptions.MaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout == 0 {
+		opts.IdleConnTimeout = DefaultTransportOptions.IdleConnTimeout
+	}
+	return func() *http.Client {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = opts.MaxIdleConns
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+		transport.IdleConnTimeout = opts.IdleConnTimeout
+		transport.ForceAttemptHTTP2 = !opts.DisableHTTP2
+		if opts.DisableHTTP2 {
+			// a non-nil, empty map turns off HTTP/2 upgrades
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+		return &http.Client{Transport: transport}
+	}
+}
+
+// Create an SQS that sends its requests through the given http.RoundTripper.
+// This is the seam for intercepting requests, e.g. to serve canned responses in tests.
+func NewSQSWithTransport(region *Region, cred *auth.Credentials, rt http.RoundTripper) *SQS {
+	return &SQS{
+		Credentials: cred,
+		Region:      region,
+		ClientFactory: func() *http.Client {
+			return &http.Client{Transport: rt}
+		},
+	}
+}
+
+// Check a queue name is one SQS accepts: up to MAX_QUEUE_NAME_LENGTH alphanumerics, hyphens and
+// underscores. For a FIFO queue (a name ending in FIFO_SUFFIX), the suffix counts towards the length,
+// and the rules for the characters apply to the base name before it.
+//
+// CreateQueue leaves the checking to SQS; call this to find out what's wrong with a name first.
+func ValidateQueueName(name string) error {
+	kind, base, maxLength := "queue name", name, MAX_QUEUE_NAME_LENGTH
+	if strings.HasSuffix(name, FIFO_SUFFIX) {
+		kind, base, maxLength = "FIFO queue base name", strings.TrimSuffix(name, FIFO_SUFFIX), MAX_QUEUE_NAME_LENGTH-len(FIFO_SUFFIX)
+	}
+	if base == "" {
+		return fmt.Errorf("sqs: %v is empty", kind)
+	}
+	if len(base) > maxLength {
+		return fmt.Errorf("sqs: %v exceeds %v characters (%v has %v)", kind, maxLength, base, len(base))
+	}
+	for _, r := range base {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("sqs: %v %v has invalid character %q; only alphanumerics, hyphens and underscores are allowed",
+				kind, base, r)
+		}
+	}
+	return nil
+}
+
 func (sqs *SQS) CreateQueue(name string) (sqsQueue *Queue, cqResponse *CreateQueueResponse, err error) {
+	return sqs.CreateQueueWithAttributes(name, nil)
+}
+
+// Like CreateQueue, but sets the queue's attributes, keyed by name (e.g. "FifoQueue": "true" for a
+// FIFO queue). Names are checked as for GetQueueAttributes.
+func (sqs *SQS) CreateQueueWithAttributes(name string, attributes map[string]string) (sqsQueue *Queue, cqResponse *CreateQueueResponse, err error) {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err = sqs.checkAttributeNames(names); err != nil {
+		return nil, nil, err
+	}
 
 	vals := sqs.defaultValues("CreateQueue")
 	vals.Set("QueueName", name)
+	for i, attrName := range names {
+		vals.Set(fmt.Sprintf("Attribute.%d.Name", i+1), attrName)
+		vals.Set(fmt.Sprintf("Attribute.%d.Value", i+1), attributes[attrName])
+	}
 
 	cqResponse = &CreateQueueResponse{}
 	err = sqs.getResults(sqs.Region.Endpoint, vals, nil, cqResponse)
@@ -58,6 +254,53 @@ func (sqs *SQS) CreateQueue(name string) (sqsQueue *Queue, cqResponse *CreateQue
 	return
 }
 
+// Create a queue and a dead-letter queue for it: the messages of the main queue received more than
+// maxReceiveCount times (1 to MAX_RECEIVE_COUNT) are moved to the dead-letter queue. The dead-letter
+// queue is named with DLQ_SUFFIX after the main queue's name (before the FIFO_SUFFIX, and made a FIFO
+// queue too, for a FIFO queue).
+//
+// The dead-letter queue is created first, then the main queue with attributes and a RedrivePolicy
+// pointing at it (replacing any in attributes). If the main queue can't be created, the dead-letter
+// queue is returned with the error, rather than deleted: it may have existed before.
+func (sqs *SQS) CreateQueueWithDLQ(name string, maxReceiveCount int, attributes map[string]string) (main, dlq *Queue, err error) {
+	if maxReceiveCount < 1 || maxReceiveCount > MAX_RECEIVE_COUNT {
+		return nil, nil, fmt.Errorf("sqs.CreateQueueWithDLQ: maxReceiveCount must be between 1 and %v, got %v",
+			MAX_RECEIVE_COUNT, maxReceiveCount)
+	}
+	dlqName := name + DLQ_SUFFIX
+	var dlqAttributes map[string]string
+	if strings.HasSuffix(name, FIFO_SUFFIX) {
+		dlqName = strings.TrimSuffix(name, FIFO_SUFFIX) + DLQ_SUFFIX + FIFO_SUFFIX
+		dlqAttributes = map[string]string{"FifoQueue": "true"}
+	}
+	dlq, _, err = sqs.CreateQueueWithAttributes(dlqName, dlqAttributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	dlqArn, err := dlq.ARN()
+	if err != nil {
+		return nil, dlq, err
+	}
+	redrivePolicy, err := json.Marshal(struct {
+		DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+		MaxReceiveCount     string `json:"maxReceiveCount"`
+	}{dlqArn, strconv.Itoa(maxReceiveCount)})
+	if err != nil {
+		return nil, dlq, err
+	}
+
+	mainAttributes := make(map[string]string, len(attributes)+1)
+	for attrName, value := range attributes {
+		mainAttributes[attrName] = value
+	}
+	mainAttributes["RedrivePolicy"] = string(redrivePolicy)
+	main, _, err = sqs.CreateQueueWithAttributes(name, mainAttributes)
+	if err != nil {
+		return nil, dlq, err
+	}
+	return main, dlq, nil
+}
+
 func (q *Queue) DeleteQueue() (*DeleteQueueResponse, error) {
 	vals := q.SQS.defaultValues("DeleteQueue")
 	delResponse := &DeleteQueueResponse{}
@@ -68,6 +311,726 @@ func (q *Queue) DeleteQueue() (*DeleteQueueResponse, error) {
 	return delResponse, nil
 }
 
+// Optional parameters for SendMessage. The zero value sends the message with the queue's defaults.
+type SendMessageOptions struct {
+	DelaySeconds           int                              // seconds (up to MAX_DELAY_SECONDS) to delay delivery; 0 uses the queue's default
+	MessageGroupId         string                           // required for FIFO queues
+	MessageDeduplicationId string                           // FIFO queues only; not needed if content-based deduplication is on
+	MessageAttributes      map[string]MessageAttributeValue // keyed by attribute name
+
+	// How long the message is expected to take to be received and handled, once delivered. If set,
+	// SendMessage first gets the queue's attributes, and fails with an error wrapping ErrExceedsRetention
+	// if the delay plus HandlingTime is longer than the queue keeps messages. Not used by SendMessageBatch.
+	HandlingTime time.Duration
+
+	// The largest message, in bytes, the queue takes (its MaximumMessageSize attribute), or
+	// FETCH_MAX_MESSAGE_SIZE to get it from the queue first. If set, SendMessage fails with an error
+	// wrapping ErrMessageTooLarge, without sending it, for a larger message (body and attributes),
+	// unless LargePayloads is set. Not used by SendMessageBatch.
+	MaxMessageSize int
+	// If set, with MaxMessageSize, stores the body of a message too large for the queue, and the body it
+	// returns is sent in its place.
+	LargePayloads LargePayloadStore
+}
+
+const FETCH_MAX_MESSAGE_SIZE = -1 // for SendMessageOptions.MaxMessageSize: get the queue's MaximumMessageSize
+
+// Stores message bodies that are too large for a queue elsewhere, e.g. in S3 as the SQS Extended
+// Client does, returning what to send in their place, e.g. a pointer to the stored body that the
+// receivers resolve. This package has no implementation yet; it's the extension point for one.
+type LargePayloadStore interface {
+	Store(ctx context.Context, q *Queue, messageBody string) (replacementBody string, err error)
+}
+
+// Set the values for the options, with each name prefixed by prefix.
+func (opts *SendMessageOptions) setValues(vals *url.Values, prefix string) {
+	if opts == nil {
+		return
+	}
+	if opts.DelaySeconds > 0 {
+		vals.Set(prefix+"DelaySeconds", strconv.Itoa(opts.DelaySeconds))
+	}
+	if opts.MessageGroupId != "" {
+		vals.Set(prefix+"MessageGroupId", opts.MessageGroupId)
+	}
+	if opts.MessageDeduplicationId != "" {
+		vals.Set(prefix+"MessageDeduplicationId", opts.MessageDeduplicationId)
+	}
+	names := make([]string, 0, len(opts.MessageAttributes))
+	for name := range opts.MessageAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		attrPrefix := fmt.Sprintf("%vMessageAttribute.%d.", prefix, i+1)
+		value := opts.MessageAttributes[name]
+		vals.Set(attrPrefix+"Name", name)
+		vals.Set(attrPrefix+"Value.DataType", value.DataType)
+		if value.BinaryValue != nil {
+			vals.Set(attrPrefix+"Value.BinaryValue", base64.StdEncoding.EncodeToString(value.BinaryValue))
+		} else {
+			vals.Set(attrPrefix+"Value.StringValue", value.StringValue)
+		}
+	}
+}
+
+// The value of a message attribute. DataType is "String", "Number" or "Binary", optionally followed by
+// a custom type (e.g. "Number.float"); Binary values go in BinaryValue, the others in StringValue.
+type MessageAttributeValue struct {
+	DataType    string
+	StringValue string `xml:",omitempty"`
+	BinaryValue []byte `xml:",omitempty"` // base64 encoded in requests and responses; decoded here
+}
+
+// The message attribute, and value, that SendBinaryMessage marks its base64 encoded messages with.
+const (
+	BINARY_ENCODING_ATTRIBUTE = "Content-Transfer-Encoding"
+	BINARY_ENCODING           = "base64"
+)
+
+// Send binary data (e.g. a protobuf or gzipped blob) as a message, which SQS can't take as is: message
+// bodies must be valid XML characters. The data is base64 encoded, and the message marked with the
+// BINARY_ENCODING_ATTRIBUTE attribute; use Message.BinaryBody to decode it when received. opts may be nil.
+func (q *Queue) SendBinaryMessage(data []byte, opts *SendMessageOptions) (*SendMessageResponse, error) {
+	binaryOpts := SendMessageOptions{}
+	if opts != nil {
+		binaryOpts = *opts
+	}
+	attrs := make(map[string]MessageAttributeValue, len(binaryOpts.MessageAttributes)+1)
+	for name, value := range binaryOpts.MessageAttributes {
+		attrs[name] = value
+	}
+	attrs[BINARY_ENCODING_ATTRIBUTE] = MessageAttributeValue{DataType: "String", StringValue: BINARY_ENCODING}
+	binaryOpts.MessageAttributes = attrs
+	return q.SendMessage(base64.StdEncoding.EncodeToString(data), &binaryOpts)
+}
+
+// Send a message to the queue. opts may be nil.
+func (q *Queue) SendMessage(messageBody string, opts *SendMessageOptions) (*SendMessageResponse, error) {
+	return q.SendMessageWithContext(context.Background(), messageBody, opts)
+}
+
+// Like SendMessage, but the request is aborted if ctx is done before it completes; the
+// returned error then wraps ctx.Err() (e.g. context.DeadlineExceeded).
+func (q *Queue) SendMessageWithContext(ctx context.Context, messageBody string, opts *SendMessageOptions) (*SendMessageResponse, error) {
+	if err := q.checkMessageDelay(opts); err != nil {
+		return nil, err
+	}
+	if opts != nil && opts.HandlingTime > 0 {
+		err := q.checkRetention(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts != nil && opts.MaxMessageSize != 0 {
+		var err error
+		messageBody, err = q.checkMessageSize(ctx, messageBody, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	vals := q.SQS.defaultValues("SendMessage")
+	vals.Set("MessageBody", messageBody)
+	opts.setValues(vals, "")
+	smResponse := &SendMessageResponse{}
+	err := q.SQS.postResults(ctx, q.Url, vals, smResponse)
+	if err != nil {
+		return nil, err
+	}
+	return smResponse, nil
+}
+
+// Send a message to be delivered at deliverAt: its DelaySeconds is set to the time from now (see
+// SQS.Now) until then, rounded up to a whole second, so it isn't delivered early. A deliverAt that
+// has passed sends it without a delay; one more than MAX_DELAY_SECONDS away is an error. opts may be
+// nil, and is left as is.
+func (q *Queue) SendMessageAt(messageBody string, deliverAt time.Time, opts *SendMessageOptions) (*SendMessageResponse, error) {
+	delay := deliverAt.Sub(q.SQS.now())
+	if delay > MAX_DELAY_SECONDS*time.Second {
+		return nil, fmt.Errorf("sqs.SendMessageAt: %v is %v away, more than the longest delay of %v seconds",
+			deliverAt, delay, MAX_DELAY_SECONDS)
+	}
+	atOpts := SendMessageOptions{}
+	if opts != nil {
+		atOpts = *opts
+	}
+	atOpts.DelaySeconds = 0
+	if delay > 0 {
+		atOpts.DelaySeconds = int((delay + time.Second - 1) / time.Second)
+	}
+	return q.SendMessage(messageBody, &atOpts)
+}
+
+// Get the current time, from Now if it's set.
+func (sqs *SQS) now() time.Time {
+	if sqs.Now != nil {
+		return sqs.Now()
+	}
+	return time.Now()
+}
+
+// Check that a message sent with opts doesn't have a DelaySeconds of its own if the queue is FIFO:
+// SQS would reject it, as FIFO queues only have a queue wide delay.
+func (q *Queue) checkMessageDelay(opts *SendMessageOptions) error {
+	if opts != nil && opts.DelaySeconds != 0 && q.IsFifo() {
+		return fmt.Errorf("sqs: FIFO queue %v doesn't allow a per-message DelaySeconds (got %v); "+
+			"set the queue's DelaySeconds attribute instead", q.Name, opts.DelaySeconds)
+	}
+	return nil
+}
+
+// Check the message fits in the queue's MaxMessageSize, storing its body with opts.LargePayloads if
+// it doesn't. Returns the body to send.
+func (q *Queue) checkMessageSize(ctx context.Context, messageBody string, opts *SendMessageOptions) (string, error) {
+	maxSize := opts.MaxMessageSize
+	if maxSize == FETCH_MAX_MESSAGE_SIZE {
+		gqaResponse, err := q.GetQueueAttributes("MaximumMessageSize")
+		if err != nil {
+			return "", err
+		}
+		qa, err := gqaResponse.QueueAttributes()
+		if err != nil {
+			return "", err
+		}
+		maxSize = qa.MaximumMessageSize
+	}
+	size := messageSize(messageBody, opts.MessageAttributes)
+	if size <= maxSize {
+		return messageBody, nil
+	}
+	if opts.LargePayloads != nil {
+		return opts.LargePayloads.Store(ctx, q, messageBody)
+	}
+	return "", fmt.Errorf("%w: %v bytes, and queue %v takes at most %v", ErrMessageTooLarge, size, q.Name, maxSize)
+}
+
+// Check the queue will keep a message sent with opts for its delay and HandlingTime.
+func (q *Queue) checkRetention(opts *SendMessageOptions) error {
+	gqaResponse, err := q.GetQueueAttributes("DelaySeconds", "MessageRetentionPeriod")
+	if err != nil {
+		return err
+	}
+	qa, err := gqaResponse.QueueAttributes()
+	if err != nil {
+		return err
+	}
+	return qa.CheckRetention(opts.DelaySeconds, opts.HandlingTime)
+}
+
+// Send a message to a FIFO queue, so that retrying the send can't deliver it twice.
+//
+// If opts has no MessageDeduplicationId, one is generated from a hash of the MessageGroupId and
+// messageBody, and used for every retry (see MaxRetries), so SQS drops the repeats. This only holds
+// for FIFO queues, and only within SQS's 5 minute deduplication window: sending the same body to the
+// same group again after that delivers it again. Standard queues ignore the deduplication ID.
+func (q *Queue) SendMessageIdempotent(messageBody string, opts SendMessageOptions) (*SendMessageResponse, error) {
+	if opts.MessageGroupId == "" {
+		return nil, fmt.Errorf("sqs.SendMessageIdempotent: MessageGroupId is required")
+	}
+	if opts.MessageDeduplicationId == "" {
+		opts.MessageDeduplicationId = deduplicationId(opts.MessageGroupId, messageBody)
+	}
+	return q.SendMessage(messageBody, &opts)
+}
+
+// Build a stable deduplication ID for a message body sent to a message group.
+func deduplicationId(messageGroupId, messageBody string) string {
+	hash := sha256.New()
+	io.WriteString(hash, messageGroupId)
+	hash.Write([]byte{0})
+	io.WriteString(hash, messageBody)
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// An entry for SendMessageBatch. The Id identifies the entry in the response, and must be unique
+// within the batch.
+type BatchSendEntry struct {
+	Id          string
+	MessageBody string
+	SendMessageOptions
+}
+
+// Send up to MAX_BATCH_ENTRIES messages to the queue.
+//
+// A batch can partially succeed: the response lists the entries that were sent in Successful, and
+// those that weren't in Failed. Failed entries don't cause an error; an error is only returned
+// if the request as a whole failed.
+func (q *Queue) SendMessageBatch(entries []BatchSendEntry) (*SendMessageBatchResponse, error) {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.Id
+	}
+	err := checkBatchIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if err = q.checkMessageDelay(&entry.SendMessageOptions); err != nil {
+			return nil, fmt.Errorf("%v (entry %v)", err, entry.Id)
+		}
+	}
+	vals := q.SQS.defaultValues("SendMessageBatch")
+	for i, entry := range entries {
+		prefix := fmt.Sprintf("SendMessageBatchRequestEntry.%d.", i+1)
+		vals.Set(prefix+"Id", entry.Id)
+		vals.Set(prefix+"MessageBody", entry.MessageBody)
+		entry.SendMessageOptions.setValues(vals, prefix)
+	}
+	batchResponse := &SendMessageBatchResponse{}
+	err = q.SQS.postResults(context.Background(), q.Url, vals, batchResponse)
+	if err != nil {
+		return nil, err
+	}
+	return batchResponse, nil
+}
+
+// Send any number of messages to the queue, split into as many SendMessageBatch requests as needed
+// to keep each within MAX_BATCH_ENTRIES entries and MAX_BATCH_BYTES of messages. Entries are sent in
+// order, and their Ids must be unique across all of them.
+//
+// The results of the batches are combined in one response, whose RequestId and AWSResponse are
+// those of the last request. If a request fails, the error is returned with the results so far, and
+// the entries after those aren't sent.
+func (q *Queue) SendMessageBatchAuto(entries []BatchSendEntry) (*SendMessageBatchResponse, error) {
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if seen[entry.Id] {
+			return nil, fmt.Errorf("sqs: duplicate batch entry Id %q", entry.Id)
+		}
+		seen[entry.Id] = true
+		if size := entry.size(); size > MAX_BATCH_BYTES {
+			return nil, fmt.Errorf("sqs.SendMessageBatchAuto: entry %v is %v bytes, more than a batch can take (%v)",
+				entry.Id, size, MAX_BATCH_BYTES)
+		}
+	}
+	combined := &SendMessageBatchResponse{}
+	for start := 0; start < len(entries); {
+		end, size := start, 0
+		for end < len(entries) && end-start < MAX_BATCH_ENTRIES && size+entries[end].size() <= MAX_BATCH_BYTES {
+			size += entries[end].size()
+			end++
+		}
+		resp, err := q.SendMessageBatch(entries[start:end])
+		if err != nil {
+			return combined, err
+		}
+		combined.Successful = append(combined.Successful, resp.Successful...)
+		combined.Failed = append(combined.Failed, resp.Failed...)
+		combined.RequestId, combined.AWSResponse = resp.RequestId, resp.AWSResponse
+		start = end
+	}
+	return combined, nil
+}
+
+// The size of the entry's message; see messageSize.
+func (entry *BatchSendEntry) size() int {
+	return messageSize(entry.MessageBody, entry.MessageAttributes)
+}
+
+// The size of a message as SQS counts it: the body, and each attribute's name, data type and value.
+func messageSize(messageBody string, attributes map[string]MessageAttributeValue) int {
+	size := len(messageBody)
+	for name, value := range attributes {
+		size += len(name) + len(value.DataType) + len(value.StringValue) + len(value.BinaryValue)
+	}
+	return size
+}
+
+// Optional parameters for ReceiveMessage. The zero value receives at most one message, with the
+// queue's default visibility timeout and wait time.
+type ReceiveMessageOptions struct {
+	MaxNumberOfMessages int // 1 to MAX_BATCH_ENTRIES; 0 receives at most one
+	VisibilityTimeout   int // seconds the messages are hidden from other receives; 0 uses the queue's default
+	WaitTimeSeconds     int // seconds (up to MAX_WAIT_TIME_SECONDS) to long poll for messages; 0 uses the queue's default
+
+	// Names of the message attributes to receive, or "All". Include BINARY_ENCODING_ATTRIBUTE to receive
+	// messages sent with SendBinaryMessage.
+	MessageAttributeNames []string
+
+	// Names of the system attributes (e.g. "SentTimestamp") to receive in Message.Attributes, or "All".
+	// RECEIVE_COUNT_ATTRIBUTE is always received, for Message.ReceiveCount.
+	AttributeNames []string
+}
+
+// The system attribute counting the times a message has been received, which ReceiveMessage always asks for.
+const RECEIVE_COUNT_ATTRIBUTE = "ApproximateReceiveCount"
+
+// The system attribute names to ask ReceiveMessage for: RECEIVE_COUNT_ATTRIBUTE and those in opts, or
+// just "All" if that's one of them.
+func (opts *ReceiveMessageOptions) attributeNames() []string {
+	names := []string{RECEIVE_COUNT_ATTRIBUTE}
+	if opts == nil {
+		return names
+	}
+	for _, name := range opts.AttributeNames {
+		if name == "All" {
+			return []string{"All"}
+		}
+		if name != RECEIVE_COUNT_ATTRIBUTE {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Receive messages from the queue. opts may be nil.
+//
+// An empty response is not an error. If opts.WaitTimeSeconds was set, ReceiveMessageResponse.LongPollTimedOut
+// tells whether the wait elapsed with no messages, i.e. the queue is idle.
+func (q *Queue) ReceiveMessage(opts *ReceiveMessageOptions) (*ReceiveMessageResponse, error) {
+	return q.ReceiveMessageWithContext(context.Background(), opts)
+}
+
+// Like ReceiveMessage, but the request (e.g. a long poll) is aborted if ctx is done before it completes.
+func (q *Queue) ReceiveMessageWithContext(ctx context.Context, opts *ReceiveMessageOptions) (*ReceiveMessageResponse, error) {
+	vals := q.SQS.defaultValues("ReceiveMessage")
+	rmResponse := &ReceiveMessageResponse{}
+	if opts != nil {
+		if opts.MaxNumberOfMessages > 0 {
+			vals.Set("MaxNumberOfMessages", strconv.Itoa(opts.MaxNumberOfMessages))
+		}
+		if opts.VisibilityTimeout > 0 {
+			vals.Set("VisibilityTimeout", strconv.Itoa(opts.VisibilityTimeout))
+		}
+		if opts.WaitTimeSeconds > 0 {
+			vals.Set("WaitTimeSeconds", strconv.Itoa(opts.WaitTimeSeconds))
+		}
+		for i, name := range opts.MessageAttributeNames {
+			vals.Set(fmt.Sprintf("MessageAttributeName.%d", i+1), name)
+		}
+		rmResponse.WaitTimeSeconds = opts.WaitTimeSeconds
+	}
+	for i, name := range opts.attributeNames() {
+		vals.Set(fmt.Sprintf("AttributeName.%d", i+1), name)
+	}
+	start := time.Now()
+	err := q.SQS.getStreamedResults(ctx, q.Url, vals, nil, rmResponse)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rmResponse.Messages {
+		err = rmResponse.Messages[i].decodeBinaryValues()
+		if err != nil {
+			return nil, err
+		}
+		err = rmResponse.Messages[i].checkAttributesMD5()
+		if err != nil {
+			return nil, err
+		}
+		rmResponse.Messages[i].setReceiveCount()
+		// the visibility timeout starts some time during the call; start is the earliest it could
+		rmResponse.Messages[i].ReceivedAt = start
+		if opts != nil {
+			rmResponse.Messages[i].VisibilityTimeout = opts.VisibilityTimeout
+		}
+	}
+	rmResponse.Elapsed = time.Since(start)
+	return rmResponse, nil
+}
+
+// Receive up to n messages from the queue, calling ReceiveMessage (for up to MAX_BATCH_ENTRIES
+// each time) until there are n, or a call returns no new messages. A message received twice is only
+// returned once.
+//
+// waitTimeSeconds is the wait budget for all the calls together: each call long polls for what is left
+// of it, and no call is made once it's spent. 0 uses the queue's default wait time for every call.
+// visibilityTimeout is as for ReceiveMessageOptions.
+//
+// If a call fails, the messages already received are returned along with the error: they are hidden
+// from other receives until their visibility timeout elapses.
+func (q *Queue) ReceiveUpTo(n int, waitTimeSeconds, visibilityTimeout int) ([]Message, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("sqs.ReceiveUpTo: n must be at least 1, got %v", n)
+	}
+	deadline := time.Now().Add(time.Duration(waitTimeSeconds) * time.Second)
+	seen := make(map[string]bool)
+	var messages []Message
+	for len(messages) < n {
+		opts := &ReceiveMessageOptions{VisibilityTimeout: visibilityTimeout}
+		opts.MaxNumberOfMessages = n - len(messages)
+		if opts.MaxNumberOfMessages > MAX_BATCH_ENTRIES {
+			opts.MaxNumberOfMessages = MAX_BATCH_ENTRIES
+		}
+		if waitTimeSeconds > 0 {
+			opts.WaitTimeSeconds = int((time.Until(deadline) + time.Second/2) / time.Second)
+			if opts.WaitTimeSeconds < 1 {
+				break
+			}
+			if opts.WaitTimeSeconds > MAX_WAIT_TIME_SECONDS {
+				opts.WaitTimeSeconds = MAX_WAIT_TIME_SECONDS
+			}
+		}
+		resp, err := q.ReceiveMessage(opts)
+		if err != nil {
+			return messages, err
+		}
+		received := len(messages)
+		for _, msg := range resp.Messages {
+			if !seen[msg.MessageId] {
+				seen[msg.MessageId] = true
+				messages = append(messages, msg)
+			}
+		}
+		if len(messages) == received {
+			break
+		}
+	}
+	return messages, nil
+}
+
+// Empty the queue by receiving messages, MAX_BATCH_ENTRIES at a time, and deleting them, e.g. to
+// clean up after a test without PurgeQueue's wait of up to 60 seconds. Returns the number of messages
+// deleted.
+//
+// As a receive can come back empty while there are messages (it only samples SQS's servers), Drain
+// stops after DRAIN_EMPTY_RECEIVES empty receives in a row, each long polling for DRAIN_WAIT_SECONDS.
+// Messages sent meanwhile may be drained too; delayed and in flight messages aren't. If ctx is done,
+// or a call fails, the number deleted so far is returned with the error.
+func (q *Queue) Drain(ctx context.Context) (int, error) {
+	drained := 0
+	opts := &ReceiveMessageOptions{MaxNumberOfMessages: MAX_BATCH_ENTRIES, WaitTimeSeconds: DRAIN_WAIT_SECONDS}
+	for empties := 0; empties < DRAIN_EMPTY_RECEIVES; {
+		resp, err := q.ReceiveMessageWithContext(ctx, opts)
+		if err != nil {
+			return drained, err
+		}
+		if resp.Empty() {
+			empties++
+			continue
+		}
+		empties = 0
+		for _, msg := range resp.Messages {
+			if err = ctx.Err(); err != nil {
+				return drained, err
+			}
+			if _, err = q.DeleteMessage(msg.ReceiptHandle); err != nil {
+				return drained, err
+			}
+			drained++
+		}
+	}
+	return drained, nil
+}
+
+// Get the queue's cost allocation tags.
+func (q *Queue) ListQueueTags() (*ListQueueTagsResponse, error) {
+	vals := q.SQS.defaultValues("ListQueueTags")
+	lqtResponse := &ListQueueTagsResponse{}
+	err := q.SQS.getResults(q.Url, vals, nil, lqtResponse)
+	if err != nil {
+		return nil, err
+	}
+	return lqtResponse, nil
+}
+
+// Delete a received message from the queue, so it isn't received again.
+func (q *Queue) DeleteMessage(receiptHandle string) (*DeleteMessageResponse, error) {
+	vals := q.SQS.defaultValues("DeleteMessage")
+	vals.Set("ReceiptHandle", receiptHandle)
+	dmResponse := &DeleteMessageResponse{}
+	err := q.SQS.getResults(q.Url, vals, nil, dmResponse)
+	if err != nil {
+		return nil, err
+	}
+	return dmResponse, nil
+}
+
+// Change the visibility timeout (in seconds) of a received message.
+func (q *Queue) ChangeMessageVisibility(receiptHandle string, visibilityTimeout int) (*ChangeMessageVisibilityResponse, error) {
+	vals := q.SQS.defaultValues("ChangeMessageVisibility")
+	vals.Set("ReceiptHandle", receiptHandle)
+	vals.Set("VisibilityTimeout", strconv.Itoa(visibilityTimeout))
+	cmvResponse := &ChangeMessageVisibilityResponse{}
+	err := q.SQS.getResults(q.Url, vals, nil, cmvResponse)
+	if err != nil {
+		return nil, err
+	}
+	return cmvResponse, nil
+}
+
+// An entry for ChangeMessageVisibilityBatch. The Id identifies the entry in the response, and must be
+// unique within the batch.
+type BatchVisibilityEntry struct {
+	Id                string
+	ReceiptHandle     string
+	VisibilityTimeout int // in seconds
+}
+
+// Change the visibility timeout of up to MAX_BATCH_ENTRIES received messages.
+// Entries that failed are listed in the response's Failed field; they don't cause an error.
+func (q *Queue) ChangeMessageVisibilityBatch(entries []BatchVisibilityEntry) (*ChangeMessageVisibilityBatchResponse, error) {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.Id
+	}
+	err := checkBatchIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	vals := q.SQS.defaultValues("ChangeMessageVisibilityBatch")
+	for i, entry := range entries {
+		prefix := fmt.Sprintf("ChangeMessageVisibilityBatchRequestEntry.%d.", i+1)
+		vals.Set(prefix+"Id", entry.Id)
+		vals.Set(prefix+"ReceiptHandle", entry.ReceiptHandle)
+		vals.Set(prefix+"VisibilityTimeout", strconv.Itoa(entry.VisibilityTimeout))
+	}
+	batchResponse := &ChangeMessageVisibilityBatchResponse{}
+	err = q.SQS.getResults(q.Url, vals, nil, batchResponse)
+	if err != nil {
+		return nil, err
+	}
+	return batchResponse, nil
+}
+
+// Batch entry IDs must match this, and be unique within the batch.
+var batchIdPattern = regexp.MustCompile("^[A-Za-z0-9_-]{1,80}$")
+
+// Check the number of entries in a batch, and their IDs, before sending it; SQS's own
+// InvalidBatchEntryId error doesn't say which ID was wrong.
+func checkBatchIds(ids []string) error {
+	if len(ids) < 1 || len(ids) > MAX_BATCH_ENTRIES {
+		return fmt.Errorf("sqs: a batch must have between 1 and %v entries, got %v", MAX_BATCH_ENTRIES, len(ids))
+	}
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !batchIdPattern.MatchString(id) {
+			return fmt.Errorf("sqs: invalid batch entry Id %q, must be 1 to 80 of A-Z, a-z, 0-9, _ and -", id)
+		}
+		if seen[id] {
+			return fmt.Errorf("sqs: duplicate batch entry Id %q", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// The queue attribute names documented by SQS, and "All".
+var QueueAttributeNames = []string{"All", "ApproximateNumberOfMessages", "ApproximateNumberOfMessagesDelayed",
+	"ApproximateNumberOfMessagesNotVisible", "ContentBasedDeduplication", "CreatedTimestamp", "DeduplicationScope",
+	"DelaySeconds", "FifoQueue", "FifoThroughputLimit", "KmsDataKeyReusePeriodSeconds", "KmsMasterKeyId",
+	"LastModifiedTimestamp", "MaximumMessageSize", "MessageRetentionPeriod", "Policy", "QueueArn",
+	"ReceiveMessageWaitTimeSeconds", "RedriveAllowPolicy", "RedrivePolicy", "SqsManagedSseEnabled",
+	"VisibilityTimeout"}
+
+// Check that the attribute names are in QueueAttributeNames, unless sqs.AllowUnknownAttributes is set.
+func (sqs *SQS) checkAttributeNames(names []string) error {
+	if sqs.AllowUnknownAttributes {
+		return nil
+	}
+next:
+	for _, name := range names {
+		for _, known := range QueueAttributeNames {
+			if name == known {
+				continue next
+			}
+		}
+		for _, known := range QueueAttributeNames {
+			if strings.EqualFold(name, known) {
+				return fmt.Errorf("sqs: unknown queue attribute name %q (did you mean %q?)", name, known)
+			}
+		}
+		return fmt.Errorf("sqs: unknown queue attribute name %q", name)
+	}
+	return nil
+}
+
+// Get attributes of the queue, e.g. "VisibilityTimeout", or "All" for all of them.
+// See QueueAttributes for a typed view of the response.
+//
+// Names that aren't in QueueAttributeNames are rejected without a request being made, unless
+// AllowUnknownAttributes is set.
+func (q *Queue) GetQueueAttributes(attributeNames ...string) (*GetQueueAttributesResponse, error) {
+	err := q.SQS.checkAttributeNames(attributeNames)
+	if err != nil {
+		return nil, err
+	}
+	vals := q.SQS.defaultValues("GetQueueAttributes")
+	for i, name := range attributeNames {
+		vals.Set(fmt.Sprintf("AttributeName.%d", i+1), name)
+	}
+	gqaResponse := &GetQueueAttributesResponse{}
+	err = q.SQS.getResults(q.Url, vals, nil, gqaResponse)
+	if err != nil {
+		return nil, err
+	}
+	return gqaResponse, nil
+}
+
+// Set attributes of the queue, keyed by name, e.g. "VisibilityTimeout" or "Policy". The request is
+// POSTed, as values such as policies can be long. Names are checked as for GetQueueAttributes.
+func (q *Queue) SetQueueAttributes(attributes map[string]string) (*SetQueueAttributesResponse, error) {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	err := q.SQS.checkAttributeNames(names)
+	if err != nil {
+		return nil, err
+	}
+	vals := q.SQS.defaultValues("SetQueueAttributes")
+	for i, name := range names {
+		vals.Set(fmt.Sprintf("Attribute.%d.Name", i+1), name)
+		vals.Set(fmt.Sprintf("Attribute.%d.Value", i+1), attributes[name])
+	}
+	sqaResponse := &SetQueueAttributesResponse{}
+	err = q.SQS.postResults(context.Background(), q.Url, vals, sqaResponse)
+	if err != nil {
+		return nil, err
+	}
+	return sqaResponse, nil
+}
+
+// Returned, wrapped, by CheckAccess when the queue doesn't exist, or the credentials can't access it.
+var (
+	ErrQueueDoesNotExist = errors.New("sqs: queue does not exist")
+	ErrAccessDenied      = errors.New("sqs: access to the queue is denied")
+)
+
+// Check that the queue exists and the credentials can access it, with a cheap request for its
+// QueueArn, e.g. at startup rather than on the first send. The error wraps ErrQueueDoesNotExist or
+// ErrAccessDenied, as well as the *ErrorResponse, if SQS said so; other errors are returned as is.
+func (q *Queue) CheckAccess() error {
+	_, err := q.GetQueueAttributes("QueueArn")
+	var errResponse *ErrorResponse
+	if !errors.As(err, &errResponse) {
+		return err
+	}
+	switch errResponse.Err.Code {
+	case "AWS.SimpleQueueService.NonExistentQueue", "QueueDoesNotExist":
+		return fmt.Errorf("%w: %v: %w", ErrQueueDoesNotExist, q.Url, err)
+	case "AccessDenied", "AccessDeniedException":
+		return fmt.Errorf("%w: %v: %w", ErrAccessDenied, q.Url, err)
+	}
+	return err
+}
+
+// Build the ARN of a queue, e.g. "arn:aws:sqs:us-east-1:123456789012:MyQueue". The partition
+// (aws, aws-us-gov or aws-cn) is chosen from the region.
+func QueueARN(region, accountId, name string) string {
+	return fmt.Sprintf("arn:%s:sqs:%s:%s:%s", partition(region), region, accountId, name)
+}
+
+// Get the ARN of the queue. It is built from the account ID in the queue's Url if there is one,
+// otherwise it is fetched with GetQueueAttributes.
+func (q *Queue) ARN() (string, error) {
+	if u, err := url.Parse(q.Url); err == nil {
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) == 2 && parts[0] != "" && parts[1] == q.Name {
+			return QueueARN(q.SQS.Region.Name, parts[0], q.Name), nil
+		}
+	}
+	gqaResp, err := q.GetQueueAttributes("QueueArn")
+	if err != nil {
+		return "", err
+	}
+	arn := gqaResp.AttributeMap()["QueueArn"]
+	if arn == "" {
+		return "", fmt.Errorf("sqs.Queue.ARN: No QueueArn attribute for queue %v", q.Name)
+	}
+	return arn, nil
+}
+
 // Get queue for a given name and AWS Account ID.
 // If accountId is an empty string (""), returns queues for the current requesting account.
 func (sqs *SQS) GetQueue(queueName, accountId string) (queue *Queue, gqResp *GetQueueResponse, err error) {
@@ -94,7 +1057,7 @@ func (sqs *SQS) ListQueues(queueNamePrefix string) (queues []Queue, lqResp *List
 		vals.Set("QueueNamePrefix", queueNamePrefix)
 	}
 	lqResp = &ListQueuesResponse{}
-	err = sqs.getResults(sqs.Region.Endpoint, vals, nil, lqResp)
+	err = sqs.getStreamedResults(context.Background(), sqs.Region.Endpoint, vals, nil, lqResp)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -107,22 +1070,159 @@ func (sqs *SQS) ListQueues(queueNamePrefix string) (queues []Queue, lqResp *List
 	return
 }
 
+// Delete all the queues with a name beginning with prefix, several at a time. The names of the deleted
+// queues are returned in deleted (sorted), and the errors for the queues that couldn't be deleted in
+// errs, keyed by queue name. If the queues can't be listed, the error is in errs under the key "".
+//
+// Meant for cleaning up after tests; prefix must not be empty, so this can't delete every queue.
+func (sqs *SQS) DeleteQueuesByPrefix(prefix string) (deleted []string, errs map[string]error) {
+	errs = make(map[string]error)
+	if prefix == "" {
+		errs[""] = fmt.Errorf("sqs.DeleteQueuesByPrefix: prefix must not be empty")
+		return
+	}
+	queues, _, err := sqs.ListQueues(prefix)
+	if err != nil {
+		errs[""] = err
+		return
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan bool, MAX_PARALLEL_DELETES)
+	for i := range queues {
+		wg.Add(1)
+		sem <- true
+		go func(q *Queue) {
+			defer func() { <-sem; wg.Done() }()
+			_, err := q.DeleteQueue()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[q.Name] = err
+			} else {
+				deleted = append(deleted, q.Name)
+			}
+		}(&queues[i])
+	}
+	wg.Wait()
+	sort.Strings(deleted)
+	return
+}
+
+// List the queues that have the tag key, set to value. SQS can't filter queues by tag, so every queue's
+// tags are fetched, several at a time. The matching queues are returned sorted by name, and the
+// errors for the queues whose tags couldn't be fetched in errs, keyed by queue name. If the queues
+// can't be listed, the error is in errs under the key "".
+func (sqs *SQS) ListQueuesByTag(key, value string) (queues []Queue, errs map[string]error) {
+	errs = make(map[string]error)
+	all, _, err := sqs.ListQueues("")
+	if err != nil {
+		errs[""] = err
+		return
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan bool, MAX_PARALLEL_TAG_GETS)
+	for i := range all {
+		wg.Add(1)
+		sem <- true
+		go func(q *Queue) {
+			defer func() { <-sem; wg.Done() }()
+			tagsResp, err := q.ListQueueTags()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[q.Name] = err
+			} else if v, ok := tagsResp.Tag(key); ok && v == value {
+				queues = append(queues, *q)
+			}
+		}(&all[i])
+	}
+	wg.Wait()
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Name < queues[j].Name })
+	return
+}
+
+// Build the error an error response is unmarshalled to; see NewErrorResponse.
+func (sqs *SQS) newErrorResponse() BodyUnmarshallerError {
+	if sqs.NewErrorResponse != nil {
+		return sqs.NewErrorResponse()
+	}
+	return &ErrorResponse{}
+}
+
 // GET results for a given uri, values, expected.
 func (sqs *SQS) getResults(uri string, values *url.Values, body io.Reader, goodResponse BodyUnmarshaller) (err error) {
-	url := fmt.Sprintf("%v/?%v", uri, values.Encode())
-	req, err := sign4.NewReusableRequest("GET", url, body)
+	start := time.Now()
+	httpResp, attempts, err := sqs.get(context.Background(), uri, values, body)
 	if err != nil {
 		return
 	}
-	httpResp, err := sqs.makeRequest(req)
+	errResponse := sqs.newErrorResponse()
+	err = unmarshalResponse(httpResp, goodResponse, errResponse, sqs.maxResponseBytes())
+	setRequestInfo(RequestInfo{Attempts: attempts, Elapsed: time.Since(start)}, goodResponse, errResponse)
+	return
+}
+
+// Like getResults, but decodes the response straight from the body, without buffering it first.
+// Use for operations that can have large responses. RawResponse is only kept if sqs.Debug is set.
+func (sqs *SQS) getStreamedResults(ctx context.Context, uri string, values *url.Values, body io.Reader, goodResponse BodyUnmarshaller) (err error) {
+	start := time.Now()
+	httpResp, attempts, err := sqs.get(ctx, uri, values, body)
 	if err != nil {
 		return
 	}
-	errResponse := &ErrorResponse{}
-	err = unmarshalResponse(httpResp, goodResponse, errResponse)
+	errResponse := sqs.newErrorResponse()
+	err = decodeResponse(httpResp, goodResponse, errResponse, sqs.Debug, sqs.maxResponseBytes())
+	setRequestInfo(RequestInfo{Attempts: attempts, Elapsed: time.Since(start)}, goodResponse, errResponse)
 	return
 }
 
+// POST values (form encoded) to the uri, and unmarshal the results.
+func (sqs *SQS) postResults(ctx context.Context, uri string, values *url.Values, goodResponse BodyUnmarshaller) (err error) {
+	start := time.Now()
+	httpResp, attempts, err := sqs.post(ctx, uri, values)
+	if err != nil {
+		return
+	}
+	errResponse := sqs.newErrorResponse()
+	err = unmarshalResponse(httpResp, goodResponse, errResponse, sqs.maxResponseBytes())
+	setRequestInfo(RequestInfo{Attempts: attempts, Elapsed: time.Since(start)}, goodResponse, errResponse)
+	if err != nil && ctx.Err() != nil {
+		// the deadline passed (or ctx was cancelled) while reading the body
+		return fmt.Errorf("sqs.postResults: %w", ctx.Err())
+	}
+	return
+}
+
+// Make a signed GET request for the uri and values. The query is sent in its canonical form, so it
+// matches what's signed exactly.
+func (sqs *SQS) get(ctx context.Context, uri string, values *url.Values, body io.Reader) (httpResp *http.Response, attempts int, err error) {
+	query, err := sign4.CanonicalQueryString(*values)
+	if err != nil {
+		return
+	}
+	url := fmt.Sprintf("%v/?%v", uri, query)
+	req, err := sign4.NewReusableRequest("GET", url, body)
+	if err != nil {
+		return
+	}
+	return sqs.makeRequest(ctx, req)
+}
+
+// Make a signed POST request to the uri, with the values form encoded in the body.
+// The encoded form is the only copy of the body: it's hashed and sent from the same string.
+func (sqs *SQS) post(ctx context.Context, uri string, values *url.Values) (httpResp *http.Response, attempts int, err error) {
+	req, err := sign4.NewReusableRequest("POST", uri+"/", strings.NewReader(values.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return sqs.makeRequest(ctx, req)
+}
+
 func (sqs *SQS) defaultValues(action string) (vals *url.Values) {
 	vals = &url.Values{}
 	vals.Set("Action", action)
@@ -131,25 +1231,208 @@ func (sqs *SQS) defaultValues(action string) (vals *url.Values) {
 	return
 }
 
-func (sqs *SQS) makeRequest(rreq *sign4.ReusableRequest) (resp *http.Response, err error) {
-	cred := sqs.Credentials
-	hreq, err := rreq.Sign(cred.AccessKey, cred.SecretKey, sqs.Region.Name, SERVICE_NAME)
+// The request most recently signed with DryRun set, or nil if there hasn't been one. Its body, if
+// any, can be read (once).
+func (sqs *SQS) LastRequest() *http.Request {
+	sqs.lastRequestMu.Lock()
+	defer sqs.lastRequestMu.Unlock()
+	return sqs.lastRequest
+}
+
+type maxRetriesKey struct{}
+
+// Get a context that overrides SQS.MaxRetries for the calls made with it (e.g. with
+// SendMessageWithContext), retrying them up to maxRetries times instead.
+//
+// Retrying is safe for idempotent operations: the reads (ListQueues, GetQueue, GetQueueAttributes,
+// ListQueueTags) and CreateQueue, DeleteQueue, SetQueueAttributes, DeleteMessage and
+// ChangeMessageVisibility. It isn't for SendMessage and SendMessageBatch to a standard queue, where a
+// retry after a lost response sends the message again (see SendMessageIdempotent for FIFO queues), or
+// for ReceiveMessage, where the messages of a lost response stay hidden until their visibility timeout.
+func WithMaxRetries(ctx context.Context, maxRetries int) context.Context {
+	return context.WithValue(ctx, maxRetriesKey{}, maxRetries)
+}
+
+// The number of times to retry a request made with ctx: MaxRetries, unless ctx overrides it.
+func (sqs *SQS) maxRetries(ctx context.Context) int {
+	if maxRetries, ok := ctx.Value(maxRetriesKey{}).(int); ok {
+		return maxRetries
+	}
+	return sqs.MaxRetries
+}
+
+// Sign and send the request, retrying up to MaxRetries (or WithMaxRetries) times after a network error or
+// a server fault (see isServerFault).
+// Each attempt is signed afresh, with the current time.
+//
+// A redirect is followed once: the request is re-signed for the region of the new location (see
+// awsclient.RedirectTarget), which is then used for any retries. If that fails, the error is an
+// *awsclient.RedirectError.
+func (sqs *SQS) makeRequest(ctx context.Context, rreq *sign4.ReusableRequest) (resp *http.Response, attempts int, err error) {
+	client := awsclient.WithoutRedirects(sqs.httpClient())
+	if sqs.Region.Name == "" {
+		return nil, 0, ErrNoRegion
+	}
+	// SQS must not get the x-amz-content-sha256 header, so the default sign4.PAYLOAD_HASH_CANONICAL_ONLY
+	// mode is used
+	signer := &sign4.Signer{AccessKey: sqs.Credentials.AccessKey, SecretKey: sqs.Credentials.SecretKey,
+		Region: sqs.Region.Name, Service: SERVICE_NAME, Logger: sqs.Logger}
+	if sqs.Host != "" {
+		rreq.Host = sqs.Host
+	}
+	maxRetries := sqs.maxRetries(ctx)
+	redirected := false
+	var delay time.Duration // before the last retry
+	for attempt := 0; ; attempt++ {
+		var hreq *http.Request
+		if attempt == 0 {
+			hreq, err = signer.Sign(rreq)
+		} else {
+			hreq, err = signer.Resign(rreq)
+		}
+		if err != nil {
+			return
+		}
+		if sqs.DryRun {
+			sqs.lastRequestMu.Lock()
+			sqs.lastRequest = hreq
+			sqs.lastRequestMu.Unlock()
+			return nil, 0, ErrDryRun
+		}
+
+		attempts++
+		resp, err = client.Do(hreq.WithContext(ctx))
+		if err == nil && awsclient.IsRedirect(resp.StatusCode) && !redirected {
+			redirected = true
+			var location *url.URL
+			location, signer.Region, err = awsclient.RedirectTarget(resp, signer.Region)
+			if err != nil {
+				return nil, attempts, err
+			}
+			rreq.URL, rreq.Host = location, location.Host
+			hreq, err = signer.Resign(rreq)
+			if err != nil {
+				return
+			}
+			attempts++
+			resp, err = client.Do(hreq.WithContext(ctx))
+			if err == nil && awsclient.IsRedirect(resp.StatusCode) {
+				resp.Body.Close()
+				return nil, attempts, &awsclient.RedirectError{StatusCode: resp.StatusCode, Location: resp.Header.Get("Location"), Region: signer.Region}
+			}
+		}
+
+		retry := err != nil || sqs.isServerFault(resp)
+		if !retry || attempt >= maxRetries || ctx.Err() != nil {
+			return
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		delay = retryBackoff.Next(delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, attempts, ctx.Err()
+		}
+	}
+}
+
+// An error response that tells a client fault, which retrying can't fix, from a server fault, which
+// it might. *ErrorResponse is one; an error from NewErrorResponse can be, too.
+type faultTyper interface {
+	IsClientError() bool
+	IsServerError() bool
+}
+
+// Whether an error response is for a server fault, and so worth retrying: one whose body decodes to a
+// faultTyper that says so, whatever its status, or else any 5xx. The body is read, and replaced, to
+// decode it.
+func (sqs *SQS) isServerFault(resp *http.Response) bool {
+	if resp.StatusCode < 400 {
+		return false
+	}
+	errResponse := sqs.newErrorResponse()
+	typed, ok := errResponse.(faultTyper)
+	if !ok {
+		return resp.StatusCode >= 500
+	}
+	if xml.Unmarshal(bufferBody(resp, sqs.maxResponseBytes()), errResponse) != nil {
+		return resp.StatusCode >= 500
+	}
+	errResponse.SetStatusCode(resp.StatusCode)
+	return typed.IsServerError()
+}
+
+// Read the (decompressed) body of a response, replacing it with what was read, for it to be read again.
+// An error reading it is returned again, after the data, when the replacement is read.
+func bufferBody(resp *http.Response, maxBytes int64) []byte {
+	var data []byte
+	body, err := responseBody(resp, maxBytes)
+	if err == nil {
+		data, err = ioutil.ReadAll(body)
+	}
+	resp.Body.Close()
+	resp.Header.Del("Content-Encoding")
+	var replacement io.Reader = bytes.NewReader(data)
 	if err != nil {
-		return
+		replacement = io.MultiReader(replacement, errorReader{err})
 	}
+	resp.Body = ioutil.NopCloser(replacement)
+	return data
+}
 
-	client := sqs.ClientFactory()
-	resp, err = client.Do(hreq)
-	return
+// An io.Reader that always fails with err.
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// Close the idle connections held by the http.Client's transport, e.g. when shutting down.
+// The SQS can still be used afterwards; new connections are opened as needed.
+//
+// With DefaultClientFactory, this closes the idle connections of http.DefaultClient.
+func (sqs *SQS) Close() {
+	sqs.httpClient().CloseIdleConnections()
+}
+
+// Get the http.Client for this SQS, building it with the ClientFactory on the first call.
+// If no ClientFactory is set, DefaultClientFactory is used.
+func (sqs *SQS) httpClient() *http.Client {
+	sqs.clientOnce.Do(func() {
+		factory := sqs.ClientFactory
+		if factory == nil {
+			factory = DefaultClientFactory
+		}
+		sqs.client = factory()
+	})
+	return sqs.client
+}
+
+// Get the MaxResponseBytes, or DEFAULT_MAX_RESPONSE_BYTES if it isn't set.
+func (sqs *SQS) maxResponseBytes() int64 {
+	if sqs.MaxResponseBytes > 0 {
+		return sqs.MaxResponseBytes
+	}
+	return DEFAULT_MAX_RESPONSE_BYTES
 }
 
 // Try to convert a response to a "good" type.
 // Fall back the knownError type.
 // Fall back to a generic error if neither of those work
-func unmarshalResponse(resp *http.Response, goodResponse BodyUnmarshaller, knownErrResponse BodyUnmarshallerError) (err error) {
+func unmarshalResponse(resp *http.Response, goodResponse BodyUnmarshaller, knownErrResponse BodyUnmarshallerError, maxBytes int64) (err error) {
 
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	bodyReader, err := responseBody(resp, maxBytes)
+	if err != nil {
+		return
+	}
+	body, err := ioutil.ReadAll(bodyReader)
 	if err != nil {
 		return
 	}
@@ -168,11 +1451,98 @@ func unmarshalResponse(resp *http.Response, goodResponse BodyUnmarshaller, known
 		knownErrResponse.SetRawResponse(body)
 		knownErrResponse.SetStatus(resp.Status)
 		knownErrResponse.SetStatusCode(resp.StatusCode)
+		setRequestId(knownErrResponse, resp.Header)
 		return knownErrResponse
 	}
 
 	return fmt.Errorf("sqs.unmarshalResponse: Unable to unmarshal body data to either %T or %T, Status: %v, body: %s",
-		goodResponse, knownErrResponse, resp.Status, body)
+		goodResponse, knownErrResponse, resp.Status, bodyPrefix(body))
+}
+
+// Quote the start of a response body, up to MAX_ERROR_BODY_BYTES, for an error: the body is whatever
+// the server sent, so neither its size nor its content is up to us.
+func bodyPrefix(body []byte) string {
+	if len(body) <= MAX_ERROR_BODY_BYTES {
+		return fmt.Sprintf("%q", body)
+	}
+	return fmt.Sprintf("%q... (%d bytes)", body[:MAX_ERROR_BODY_BYTES], len(body))
+}
+
+// Decode a response straight from its body: to goodResponse for a 2xx status, otherwise to knownErrResponse.
+// The body is only kept (as the RawResponse) if keepRaw is set.
+func decodeResponse(resp *http.Response, goodResponse BodyUnmarshaller, knownErrResponse BodyUnmarshallerError, keepRaw bool, maxBytes int64) (err error) {
+
+	defer resp.Body.Close()
+	// drain whatever the decoder didn't read, so the connection can be reused (but not an oversized body)
+	defer io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBytes))
+
+	body, err := responseBody(resp, maxBytes)
+	if err != nil {
+		return
+	}
+	var raw *bytes.Buffer
+	if keepRaw {
+		raw = new(bytes.Buffer)
+		body = io.TeeReader(body, raw)
+	}
+
+	var target BodyUnmarshaller = goodResponse
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		target = knownErrResponse
+	}
+
+	err = xml.NewDecoder(body).Decode(target)
+	if err != nil {
+		return fmt.Errorf("sqs.decodeResponse: Unable to decode body data to %T, Status: %v, error: %w",
+			target, resp.Status, err)
+	}
+	if raw != nil {
+		target.SetRawResponse(raw.Bytes())
+	}
+	target.SetStatus(resp.Status)
+	target.SetStatusCode(resp.StatusCode)
+
+	if target == knownErrResponse {
+		setRequestId(knownErrResponse, resp.Header)
+		return knownErrResponse
+	}
+	return nil
+}
+
+// Returned (wrapped, for a streamed response) when a response body is larger than SQS.MaxResponseBytes.
+var ErrResponseTooLarge = errors.New("sqs: response body is too large")
+
+// An io.Reader that fails with ErrResponseTooLarge once more than left bytes are read.
+type maxBytesReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	// read one byte past the limit, to tell a body of exactly the limit from a larger one
+	if int64(len(p)) > m.left+1 {
+		p = p[:m.left+1]
+	}
+	n, err := m.r.Read(p)
+	m.left -= int64(n)
+	if m.left < 0 {
+		return n, ErrResponseTooLarge
+	}
+	return n, err
+}
+
+// Get a reader for the response body, decompressing it if it has a gzip Content-Encoding.
+// (http.Transport only does this itself if it added the Accept-Encoding header.) Reading more than
+// maxBytes (decompressed) fails with ErrResponseTooLarge.
+func responseBody(resp *http.Response, maxBytes int64) (io.Reader, error) {
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &maxBytesReader{gz, maxBytes}, nil
+	}
+	return &maxBytesReader{resp.Body, maxBytes}, nil
 }
 
 type BodyUnmarshaller interface {
@@ -189,7 +1559,27 @@ type BodyUnmarshallerError interface {
 type AWSResponse struct {
 	Status      string
 	StatusCode  int
-	RawResponse []byte // contains the raw xml data in the response
+	RawResponse []byte      // contains the raw xml data in the response
+	RequestInfo RequestInfo `xml:"-"`
+}
+
+// How the request for a response went, e.g. for latency and retry metrics.
+type RequestInfo struct {
+	Attempts int           // times the request was sent: 1, plus any retries, plus one if it was redirected
+	Elapsed  time.Duration // from the request first being sent to its response being read
+}
+
+func (r *AWSResponse) setRequestInfo(info RequestInfo) {
+	r.RequestInfo = info
+}
+
+// Set the RequestInfo of each response that has one (all those embedding AWSResponse).
+func setRequestInfo(info RequestInfo, responses ...BodyUnmarshaller) {
+	for _, resp := range responses {
+		if r, ok := resp.(interface{ setRequestInfo(RequestInfo) }); ok {
+			r.setRequestInfo(info)
+		}
+	}
 }
 
 func (r *AWSResponse) SetRawResponse(rawResponse []byte) {
@@ -231,6 +1621,377 @@ type ListQueuesResponse struct {
 	AWSResponse
 }
 
+type SendMessageResponse struct {
+	XMLName          xml.Name `xml:"SendMessageResponse"` //http://queue.amazonaws.com/doc/2012-11-05/
+	MessageId        string   `xml:"SendMessageResult>MessageId"`
+	MD5OfMessageBody string   `xml:"SendMessageResult>MD5OfMessageBody"`
+	SequenceNumber   string   `xml:"SendMessageResult>SequenceNumber"` // FIFO queues only; orders the messages of a group
+	RequestId        string   `xml:"ResponseMetadata>RequestId"`
+	AWSResponse
+}
+
+type SendMessageBatchResponse struct {
+	XMLName    xml.Name                      `xml:"SendMessageBatchResponse"` //http://queue.amazonaws.com/doc/2012-11-05/
+	Successful []SendMessageBatchResultEntry `xml:"SendMessageBatchResult>SendMessageBatchResultEntry"`
+	Failed     []BatchResultErrorEntry       `xml:"SendMessageBatchResult>BatchResultErrorEntry"`
+	RequestId  string                        `xml:"ResponseMetadata>RequestId"`
+	AWSResponse
+}
+
+type SendMessageBatchResultEntry struct {
+	Id, MessageId, MD5OfMessageBody string
+	SequenceNumber                  string // FIFO queues only
+}
+
+type SetQueueAttributesResponse struct {
+	XMLName   xml.Name `xml:"SetQueueAttributesResponse"` //http://queue.amazonaws.com/doc/2012-11-05/
+	RequestId string   `xml:"ResponseMetadata>RequestId"`
+	AWSResponse
+}
+
+type GetQueueAttributesResponse struct {
+	XMLName    xml.Name    `xml:"GetQueueAttributesResponse"` //http://queue.amazonaws.com/doc/2012-11-05/
+	Attributes []Attribute `xml:"GetQueueAttributesResult>Attribute"`
+	RequestId  string      `xml:"ResponseMetadata>RequestId"`
+	AWSResponse
+}
+
+type Attribute struct {
+	Name, Value string
+}
+
+// The attributes as a map of name to value.
+func (r *GetQueueAttributesResponse) AttributeMap() map[string]string {
+	m := make(map[string]string, len(r.Attributes))
+	for _, attr := range r.Attributes {
+		m[attr.Name] = attr.Value
+	}
+	return m
+}
+
+// Typed queue attributes. Fields for attributes missing from the response are left as zero values.
+type QueueAttributes struct {
+	ApproximateNumberOfMessages           int
+	ApproximateNumberOfMessagesNotVisible int
+	ApproximateNumberOfMessagesDelayed    int
+	CreatedTimestamp                      time.Time // in UTC
+	LastModifiedTimestamp                 time.Time // in UTC
+	DelaySeconds                          int
+	MaximumMessageSize                    int // in bytes
+	MessageRetentionPeriod                int // in seconds
+	ReceiveMessageWaitTimeSeconds         int
+	VisibilityTimeout                     int // in seconds
+	QueueArn                              string
+	Policy                                string
+	RedrivePolicy                         string
+	FifoQueue                             bool
+	ContentBasedDeduplication             bool
+}
+
+// The error wrapped when a message would be deleted, by the queue's retention period, before it's handled.
+var ErrExceedsRetention = errors.New("sqs: message would exceed the queue's retention period")
+
+// Returned, wrapped, by SendMessage for a message larger than SendMessageOptions.MaxMessageSize.
+var ErrMessageTooLarge = errors.New("sqs: message is too large for the queue")
+
+// Check that a message sent with delaySeconds (0 for the queue's DelaySeconds), and taking handling to
+// be received and handled after that, is kept by the queue until it's handled. Otherwise SQS silently
+// deletes it. The error returned wraps ErrExceedsRetention. If MessageRetentionPeriod isn't known, there's
+// nothing to check against.
+func (qa *QueueAttributes) CheckRetention(delaySeconds int, handling time.Duration) error {
+	if qa.MessageRetentionPeriod == 0 {
+		return nil
+	}
+	if delaySeconds == 0 {
+		delaySeconds = qa.DelaySeconds
+	}
+	delay := time.Duration(delaySeconds) * time.Second
+	retention := time.Duration(qa.MessageRetentionPeriod) * time.Second
+	if delay+handling > retention {
+		return fmt.Errorf("%w: delay %v plus handling time %v is longer than %v", ErrExceedsRetention, delay, handling, retention)
+	}
+	return nil
+}
+
+// Convert the attributes to a QueueAttributes. The timestamps, which SQS sends as seconds since
+// the epoch, are converted to times in UTC. Attributes not in QueueAttributes are ignored.
+func (r *GetQueueAttributesResponse) QueueAttributes() (qa *QueueAttributes, err error) {
+	qa = &QueueAttributes{}
+	ints := map[string]*int{
+		"ApproximateNumberOfMessages":           &qa.ApproximateNumberOfMessages,
+		"ApproximateNumberOfMessagesNotVisible": &qa.ApproximateNumberOfMessagesNotVisible,
+		"ApproximateNumberOfMessagesDelayed":    &qa.ApproximateNumberOfMessagesDelayed,
+		"DelaySeconds":                          &qa.DelaySeconds,
+		"MaximumMessageSize":                    &qa.MaximumMessageSize,
+		"MessageRetentionPeriod":                &qa.MessageRetentionPeriod,
+		"ReceiveMessageWaitTimeSeconds":         &qa.ReceiveMessageWaitTimeSeconds,
+		"VisibilityTimeout":                     &qa.VisibilityTimeout,
+	}
+	times := map[string]*time.Time{
+		"CreatedTimestamp":      &qa.CreatedTimestamp,
+		"LastModifiedTimestamp": &qa.LastModifiedTimestamp,
+	}
+	strs := map[string]*string{
+		"QueueArn":      &qa.QueueArn,
+		"Policy":        &qa.Policy,
+		"RedrivePolicy": &qa.RedrivePolicy,
+	}
+	bools := map[string]*bool{
+		"FifoQueue":                 &qa.FifoQueue,
+		"ContentBasedDeduplication": &qa.ContentBasedDeduplication,
+	}
+
+	for _, attr := range r.Attributes {
+		if p, ok := ints[attr.Name]; ok {
+			*p, err = strconv.Atoi(attr.Value)
+		} else if p, ok := times[attr.Name]; ok {
+			var secs int64
+			secs, err = strconv.ParseInt(attr.Value, 10, 64)
+			*p = time.Unix(secs, 0).UTC()
+		} else if p, ok := strs[attr.Name]; ok {
+			*p = attr.Value
+		} else if p, ok := bools[attr.Name]; ok {
+			*p, err = strconv.ParseBool(attr.Value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sqs.QueueAttributes: Bad value for %v: %v", attr.Name, err)
+		}
+	}
+	return qa, nil
+}
+
+type ReceiveMessageResponse struct {
+	XMLName   xml.Name  `xml:"ReceiveMessageResponse"` //http://queue.amazonaws.com/doc/2012-11-05/
+	Messages  []Message `xml:"ReceiveMessageResult>Message"`
+	RequestId string    `xml:"ResponseMetadata>RequestId"`
+
+	WaitTimeSeconds int           `xml:"-"` // the long poll wait asked for; 0 if the queue's default was used
+	Elapsed         time.Duration `xml:"-"` // how long the call took
+	AWSResponse
+}
+
+// A message received from a queue.
+type Message struct {
+	MessageId     string
+	ReceiptHandle string // identifies this receive of the message, e.g. to delete it
+	MD5OfBody     string
+	Body          string
+	Attributes    []Attribute `xml:"Attribute"`
+
+	MessageAttributes      []MessageAttribute `xml:"MessageAttribute"`
+	MD5OfMessageAttributes string
+
+	// The times the message has been received, including this one, from its RECEIVE_COUNT_ATTRIBUTE
+	// attribute; 0 if it doesn't have the attribute (or it's not a number).
+	ReceiveCount int `xml:"-"`
+
+	// When the ReceiveMessage call that got the message was made, and the VisibilityTimeout it asked
+	// for (0 if it used the queue's); see RemainingVisibility.
+	ReceivedAt        time.Time `xml:"-"`
+	VisibilityTimeout int       `xml:"-"`
+}
+
+// The time the message becomes visible again, if it was received at receivedAt with a visibility
+// timeout of visibilityTimeout seconds and the timeout isn't changed since.
+func (m *Message) VisibilityDeadline(receivedAt time.Time, visibilityTimeout int) time.Time {
+	return receivedAt.Add(time.Duration(visibilityTimeout) * time.Second)
+}
+
+// How long until the message becomes visible again, i.e. until its VisibilityDeadline, counting from
+// when the receive call was made, so the time spent waiting for it is already taken off. Returns 0
+// once the deadline has passed, and if the visibility timeout isn't known: it wasn't set in the
+// ReceiveMessageOptions, or the message wasn't received with ReceiveMessage. Changes to the timeout
+// after receiving (e.g. by Messages, or ChangeMessageVisibility) aren't accounted for.
+func (m *Message) RemainingVisibility() time.Duration {
+	if m.ReceivedAt.IsZero() || m.VisibilityTimeout <= 0 {
+		return 0
+	}
+	remaining := time.Until(m.VisibilityDeadline(m.ReceivedAt, m.VisibilityTimeout))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+type MessageAttribute struct {
+	Name  string
+	Value MessageAttributeValue
+}
+
+// Get a message attribute's value by name.
+func (m *Message) MessageAttribute(name string) (value MessageAttributeValue, ok bool) {
+	for _, attr := range m.MessageAttributes {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return
+}
+
+// Get a system attribute's value by name.
+func (m *Message) Attribute(name string) (value string, ok bool) {
+	for _, attr := range m.Attributes {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return
+}
+
+// Set ReceiveCount from the RECEIVE_COUNT_ATTRIBUTE attribute.
+func (m *Message) setReceiveCount() {
+	if value, ok := m.Attribute(RECEIVE_COUNT_ATTRIBUTE); ok {
+		m.ReceiveCount, _ = strconv.Atoi(value)
+	}
+}
+
+// Get the body of a message sent with SendBinaryMessage, decoding it from base64. The body of a message
+// without the BINARY_ENCODING_ATTRIBUTE attribute is returned as is.
+func (m *Message) BinaryBody() ([]byte, error) {
+	if value, ok := m.MessageAttribute(BINARY_ENCODING_ATTRIBUTE); ok && value.StringValue == BINARY_ENCODING {
+		return base64.StdEncoding.DecodeString(m.Body)
+	}
+	return []byte(m.Body), nil
+}
+
+// Decode the binary attribute values, which the XML decoder leaves base64 encoded.
+func (m *Message) decodeBinaryValues() error {
+	for i, attr := range m.MessageAttributes {
+		if attr.Value.BinaryValue == nil {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(attr.Value.BinaryValue))
+		if err != nil {
+			return fmt.Errorf("sqs: message attribute %v: %w", attr.Name, err)
+		}
+		m.MessageAttributes[i].Value.BinaryValue = decoded
+	}
+	return nil
+}
+
+// Returned, wrapped, by ReceiveMessage if the MD5OfMessageAttributes of a message doesn't match its
+// attributes, which were then corrupted on the way. None of the messages are returned; they're
+// received again once their visibility timeout passes.
+var ErrAttributesMD5Mismatch = errors.New("sqs: MD5 of message attributes doesn't match")
+
+// Check the message's attributes against its MD5OfMessageAttributes, if it has both.
+func (m *Message) checkAttributesMD5() error {
+	if m.MD5OfMessageAttributes == "" || len(m.MessageAttributes) == 0 {
+		return nil
+	}
+	attrs := make(map[string]MessageAttributeValue, len(m.MessageAttributes))
+	for _, attr := range m.MessageAttributes {
+		attrs[attr.Name] = attr.Value
+	}
+	if sum := MessageAttributesMD5(attrs); sum != m.MD5OfMessageAttributes {
+		return fmt.Errorf("%w: message %v: got %v, computed %v", ErrAttributesMD5Mismatch, m.MessageId,
+			m.MD5OfMessageAttributes, sum)
+	}
+	return nil
+}
+
+// Transport types of message attribute values, in the encoding hashed by MessageAttributesMD5.
+const (
+	ATTRIBUTE_TRANSPORT_STRING = 1 // String and Number values
+	ATTRIBUTE_TRANSPORT_BINARY = 2
+)
+
+// Compute the MD5 of message attributes as SQS does, for MD5OfMessageAttributes (hex encoded).
+// Sorted by name, each attribute is encoded as its name, its DataType, a byte for its transport
+// type, and its value (the decoded bytes, for Binary), where each of the strings and the value is
+// preceded by its length as 4 bytes, big-endian.
+//
+// See http://docs.aws.amazon.com/AWSSimpleQueueService/latest/SQSDeveloperGuide/sqs-message-metadata.html
+func MessageAttributesMD5(attrs map[string]MessageAttributeValue) string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := md5.New()
+	writeLengthPrefixed := func(b []byte) {
+		binary.Write(hash, binary.BigEndian, uint32(len(b)))
+		hash.Write(b)
+	}
+	for _, name := range names {
+		value := attrs[name]
+		writeLengthPrefixed([]byte(name))
+		writeLengthPrefixed([]byte(value.DataType))
+		if strings.HasPrefix(value.DataType, "Binary") {
+			hash.Write([]byte{ATTRIBUTE_TRANSPORT_BINARY})
+			writeLengthPrefixed(value.BinaryValue)
+		} else {
+			hash.Write([]byte{ATTRIBUTE_TRANSPORT_STRING})
+			writeLengthPrefixed([]byte(value.StringValue))
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Whether no messages were received.
+func (r *ReceiveMessageResponse) Empty() bool {
+	return len(r.Messages) == 0
+}
+
+// Whether the call long polled and no messages arrived before the wait elapsed. An empty response
+// to a call without a WaitTimeSeconds (a short poll, unless the queue has a default wait) doesn't
+// count: SQS only sampled some of its servers, so there may be messages after all.
+func (r *ReceiveMessageResponse) LongPollTimedOut() bool {
+	return r.Empty() && r.WaitTimeSeconds > 0
+}
+
+type ListQueueTagsResponse struct {
+	XMLName   xml.Name `xml:"ListQueueTagsResponse"` //http://queue.amazonaws.com/doc/2012-11-05/
+	Tags      []Tag    `xml:"ListQueueTagsResult>Tag"`
+	RequestId string   `xml:"ResponseMetadata>RequestId"`
+	AWSResponse
+}
+
+type Tag struct {
+	Key, Value string
+}
+
+// Get a tag's value by key.
+func (r *ListQueueTagsResponse) Tag(key string) (value string, ok bool) {
+	for _, tag := range r.Tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return
+}
+
+type DeleteMessageResponse struct {
+	XMLName   xml.Name `xml:"DeleteMessageResponse"` //http://queue.amazonaws.com/doc/2012-11-05/
+	RequestId string   `xml:"ResponseMetadata>RequestId"`
+	AWSResponse
+}
+
+type ChangeMessageVisibilityResponse struct {
+	XMLName   xml.Name `xml:"ChangeMessageVisibilityResponse"` //http://queue.amazonaws.com/doc/2012-11-05/
+	RequestId string   `xml:"ResponseMetadata>RequestId"`
+	AWSResponse
+}
+
+type ChangeMessageVisibilityBatchResponse struct {
+	XMLName    xml.Name                                  `xml:"ChangeMessageVisibilityBatchResponse"` //http://queue.amazonaws.com/doc/2012-11-05/
+	Successful []ChangeMessageVisibilityBatchResultEntry `xml:"ChangeMessageVisibilityBatchResult>ChangeMessageVisibilityBatchResultEntry"`
+	Failed     []BatchResultErrorEntry                   `xml:"ChangeMessageVisibilityBatchResult>BatchResultErrorEntry"`
+	RequestId  string                                    `xml:"ResponseMetadata>RequestId"`
+	AWSResponse
+}
+
+type ChangeMessageVisibilityBatchResultEntry struct {
+	Id string
+}
+
+// A failed entry in a batch request.
+type BatchResultErrorEntry struct {
+	Id, Code, Message string
+	SenderFault       bool // true if the failure was caused by the request (as opposed to SQS)
+}
+
 type ErrorResponse struct {
 	XMLName   xml.Name  `xml:"ErrorResponse"` //http://queue.amazonaws.com/doc/2012-11-05/
 	Err       ErrorInfo `xml:"Error"`
@@ -242,6 +2003,34 @@ type ErrorInfo struct {
 	Type, Code, Message, Detail string
 }
 
+// The response header holding the request ID, which error bodies don't always include.
+const HDR_REQUEST_ID = "x-amzn-RequestId"
+
+// Fill in an ErrorResponse's RequestId from the response header, if the body didn't have one.
+func setRequestId(errResponse BodyUnmarshallerError, header http.Header) {
+	if e, ok := errResponse.(*ErrorResponse); ok && e.RequestId == "" {
+		e.RequestId = header.Get(HDR_REQUEST_ID)
+	}
+}
+
+// Whether the error is the client's fault (Err.Type "Sender"), e.g. a bad parameter, so retrying the
+// request as is won't help. Without a Type, any 4xx status is.
+func (e *ErrorResponse) IsClientError() bool {
+	if e.Err.Type == "" {
+		return e.StatusCode >= 400 && e.StatusCode < 500
+	}
+	return e.Err.Type == "Sender"
+}
+
+// Whether the error is SQS's fault (Err.Type "Receiver"), e.g. an internal error, so the request can be
+// retried. Without a Type, any 5xx status is.
+func (e *ErrorResponse) IsServerError() bool {
+	if e.Err.Type == "" {
+		return e.StatusCode >= 500
+	}
+	return e.Err.Type == "Receiver"
+}
+
 func (e *ErrorResponse) Error() string {
 	return fmt.Sprintf("sqs.ErrorResponse Type: %v, Code: %v Message: %v",
 		e.Err.Type, e.Err.Code, e.Err.Message)
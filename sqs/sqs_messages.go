@@ -0,0 +1,256 @@
+package sqs
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DEFAULT_VISIBILITY_TIMEOUT = 30 // seconds; the visibility timeout of a queue that doesn't set one
+
+// Options for Messages.
+type MessagesOptions struct {
+	ReceiveMessageOptions
+
+	// Called with each error receiving messages or extending their visibility timeouts, if set.
+	// Receiving is retried with the same backoff as a failed request.
+	OnError func(error)
+
+	// If set, the IDs of the messages acknowledged are added to it, and a message received again
+	// whose ID it has, e.g. one a standard queue delivered twice, is deleted rather than sent on the
+	// channel. This cuts down on duplicates, but doesn't rule them out: a message can be received
+	// again while it's being handled. See MemoryDedupCache.
+	Dedup DedupCache
+}
+
+// The IDs of messages already handled, for MessagesOptions.Dedup. Implementations must be safe for
+// concurrent use; one backed by a shared store (e.g. Redis) dedups across consumers.
+type DedupCache interface {
+	Contains(messageId string) (bool, error)
+	Add(messageId string) error
+}
+
+// Receive messages from the queue as they arrive, long polling until ctx is done, when the channel is
+// closed. opts may be nil; unless set, VisibilityTimeout is DEFAULT_VISIBILITY_TIMEOUT and
+// WaitTimeSeconds is MAX_WAIT_TIME_SECONDS.
+//
+// The visibility timeout of each message is extended, every half timeout, until it's acknowledged
+// with ack, which deletes it from the queue. Once ctx is done, messages are no longer extended, so
+// those not acknowledged are received again after their visibility timeout. ack can still be called.
+func (q *Queue) Messages(ctx context.Context, opts *MessagesOptions) (messages <-chan Message, ack func(Message) error) {
+	c := &consumer{queue: q, inFlight: make(map[string]bool)}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.VisibilityTimeout <= 0 {
+		c.opts.VisibilityTimeout = DEFAULT_VISIBILITY_TIMEOUT
+	}
+	if c.opts.WaitTimeSeconds <= 0 {
+		c.opts.WaitTimeSeconds = MAX_WAIT_TIME_SECONDS
+	}
+	ch := make(chan Message)
+	go c.receive(ctx, ch)
+	go c.extend(ctx)
+	return ch, c.ack
+}
+
+// The state behind a Messages channel.
+type consumer struct {
+	queue *Queue
+	opts  MessagesOptions
+
+	mu       sync.Mutex
+	inFlight map[string]bool // receipt handles of the messages received and not yet acknowledged
+}
+
+// Receive messages and send them on ch, until ctx is done.
+func (c *consumer) receive(ctx context.Context, ch chan<- Message) {
+	defer close(ch)
+	var delay time.Duration // before the last retry, or 0 after a success
+	for ctx.Err() == nil {
+		resp, err := c.queue.ReceiveMessageWithContext(ctx, &c.opts.ReceiveMessageOptions)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.onError(err)
+			delay = retryBackoff.Next(delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			continue
+		}
+		delay = 0
+		for _, msg := range resp.Messages {
+			if c.duplicate(msg) {
+				continue
+			}
+			// extended while waiting to be taken from ch, too
+			c.mu.Lock()
+			c.inFlight[msg.ReceiptHandle] = true
+			c.mu.Unlock()
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Extend the visibility timeout of the messages in flight every half timeout, until ctx is done.
+func (c *consumer) extend(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(c.opts.VisibilityTimeout) * time.Second / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		handles := make([]string, 0, len(c.inFlight))
+		for handle := range c.inFlight {
+			handles = append(handles, handle)
+		}
+		c.mu.Unlock()
+
+		for start := 0; start < len(handles); start += MAX_BATCH_ENTRIES {
+			end := start + MAX_BATCH_ENTRIES
+			if end > len(handles) {
+				end = len(handles)
+			}
+			entries := make([]BatchVisibilityEntry, 0, end-start)
+			for i, handle := range handles[start:end] {
+				entries = append(entries, BatchVisibilityEntry{Id: strconv.Itoa(i), ReceiptHandle: handle,
+					VisibilityTimeout: c.opts.VisibilityTimeout})
+			}
+			resp, err := c.queue.ChangeMessageVisibilityBatch(entries)
+			if err != nil {
+				c.onError(err)
+				continue
+			}
+			for _, failed := range resp.Failed {
+				c.onError(fmt.Errorf("sqs.Messages: Unable to extend visibility timeout: %v: %v", failed.Code, failed.Message))
+			}
+		}
+	}
+}
+
+// Delete the message, and stop extending its visibility timeout.
+func (c *consumer) ack(msg Message) error {
+	_, err := c.queue.DeleteMessage(msg.ReceiptHandle)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	delete(c.inFlight, msg.ReceiptHandle)
+	c.mu.Unlock()
+	if c.opts.Dedup != nil {
+		if err = c.opts.Dedup.Add(msg.MessageId); err != nil {
+			c.onError(err)
+		}
+	}
+	return nil
+}
+
+// Whether the message was handled already, according to the Dedup cache, in which case it's deleted.
+// If the cache can't tell, the message is taken to be new.
+func (c *consumer) duplicate(msg Message) bool {
+	if c.opts.Dedup == nil {
+		return false
+	}
+	seen, err := c.opts.Dedup.Contains(msg.MessageId)
+	if err != nil {
+		c.onError(err)
+		return false
+	}
+	if seen {
+		if _, err = c.queue.DeleteMessage(msg.ReceiptHandle); err != nil {
+			c.onError(err)
+		}
+	}
+	return seen
+}
+
+func (c *consumer) onError(err error) {
+	if c.opts.OnError != nil {
+		c.opts.OnError(err)
+	}
+}
+
+// A DedupCache in memory, for a single consumer. IDs expire TTL after they're added, and once there
+// are MaxSize of them, the oldest is dropped for each one added.
+type MemoryDedupCache struct {
+	TTL     time.Duration
+	MaxSize int
+	// Gets the current time; if nil, time.Now is used. For testing.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List               // of *dedupEntry, oldest first
+	entries map[string]*list.Element // by message ID
+}
+
+type dedupEntry struct {
+	messageId string
+	expires   time.Time
+}
+
+// Create a MemoryDedupCache.
+func NewMemoryDedupCache(ttl time.Duration, maxSize int) *MemoryDedupCache {
+	return &MemoryDedupCache{TTL: ttl, MaxSize: maxSize}
+}
+
+// Whether the ID was added less than TTL ago (and hasn't been dropped since). Never fails.
+func (m *MemoryDedupCache) Contains(messageId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+	_, ok := m.entries[messageId]
+	return ok, nil
+}
+
+// Add the ID, or renew it if it's there already. Never fails.
+func (m *MemoryDedupCache) Add(messageId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+	if elem, ok := m.entries[messageId]; ok {
+		m.order.Remove(elem)
+	}
+	m.entries[messageId] = m.order.PushBack(&dedupEntry{messageId, m.now().Add(m.TTL)})
+	for m.MaxSize > 0 && m.order.Len() > m.MaxSize {
+		m.remove(m.order.Front())
+	}
+	return nil
+}
+
+// Drop the expired IDs, which are the oldest, as they all have the same TTL. Called with mu held.
+func (m *MemoryDedupCache) expire() {
+	if m.order == nil {
+		m.order = list.New()
+		m.entries = make(map[string]*list.Element)
+	}
+	now := m.now()
+	for elem := m.order.Front(); elem != nil && !now.Before(elem.Value.(*dedupEntry).expires); elem = m.order.Front() {
+		m.remove(elem)
+	}
+}
+
+func (m *MemoryDedupCache) remove(elem *list.Element) {
+	delete(m.entries, elem.Value.(*dedupEntry).messageId)
+	m.order.Remove(elem)
+}
+
+func (m *MemoryDedupCache) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,151 @@
+package sqs
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const POLICY_VERSION = "2012-10-17" // the policy language version of the policies SetPolicy sets
+
+// An access policy, as in a queue's "Policy" attribute.
+type Policy struct {
+	Version   string
+	Id        string `json:",omitempty"`
+	Statement []PolicyStatement
+}
+
+// A statement of an access policy. It's serialized to the policy's JSON form; a single Action or
+// condition value may be a string or a list there, and both are parsed.
+type PolicyStatement struct {
+	Sid       string
+	Effect    string                         // "Allow" or "Deny"; SetPolicy uses "Allow" if empty
+	Principal map[string][]string            // e.g. {"Service": {"sns.amazonaws.com"}}; nil for anyone ("*")
+	Action    []string                       // e.g. "sqs:SendMessage"
+	Resource  string                         // the queue's ARN; SetPolicy uses the queue's if empty
+	Condition map[string]map[string][]string // operator to key to values, e.g. {"ArnEquals": {"aws:SourceArn": {arn}}}
+}
+
+// A statement allowing an SNS topic to send its notifications to the queue.
+func AllowSNSTopic(topicArn string) PolicyStatement {
+	return PolicyStatement{
+		Effect:    "Allow",
+		Principal: map[string][]string{"Service": {"sns.amazonaws.com"}},
+		Action:    []string{"sqs:SendMessage"},
+		Condition: map[string]map[string][]string{"ArnEquals": {"aws:SourceArn": {topicArn}}},
+	}
+}
+
+// The JSON form of a PolicyStatement.
+type policyStatementJSON struct {
+	Sid       string `json:",omitempty"`
+	Effect    string
+	Principal json.RawMessage
+	Action    stringList
+	Resource  string
+	Condition map[string]map[string]stringList `json:",omitempty"`
+}
+
+// A list of strings, which may be a single string in JSON.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+func (stmt PolicyStatement) MarshalJSON() ([]byte, error) {
+	principal := json.RawMessage(`"*"`)
+	if stmt.Principal != nil {
+		p, err := json.Marshal(stmt.Principal)
+		if err != nil {
+			return nil, err
+		}
+		principal = p
+	}
+	var condition map[string]map[string]stringList
+	if stmt.Condition != nil {
+		condition = make(map[string]map[string]stringList, len(stmt.Condition))
+		for op, keys := range stmt.Condition {
+			condition[op] = make(map[string]stringList, len(keys))
+			for key, values := range keys {
+				condition[op][key] = values
+			}
+		}
+	}
+	return json.Marshal(policyStatementJSON{Sid: stmt.Sid, Effect: stmt.Effect, Principal: principal,
+		Action: stmt.Action, Resource: stmt.Resource, Condition: condition})
+}
+
+func (stmt *PolicyStatement) UnmarshalJSON(data []byte) error {
+	var j policyStatementJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*stmt = PolicyStatement{Sid: j.Sid, Effect: j.Effect, Action: j.Action, Resource: j.Resource}
+	var anyone string
+	if err := json.Unmarshal(j.Principal, &anyone); err != nil || anyone != "*" {
+		principal := map[string]stringList{}
+		if err := json.Unmarshal(j.Principal, &principal); err != nil {
+			return fmt.Errorf("sqs: invalid policy statement Principal %s: %w", j.Principal, err)
+		}
+		stmt.Principal = make(map[string][]string, len(principal))
+		for kind, ids := range principal {
+			stmt.Principal[kind] = ids
+		}
+	}
+	if j.Condition != nil {
+		stmt.Condition = make(map[string]map[string][]string, len(j.Condition))
+		for op, keys := range j.Condition {
+			stmt.Condition[op] = make(map[string][]string, len(keys))
+			for key, values := range keys {
+				stmt.Condition[op][key] = values
+			}
+		}
+	}
+	return nil
+}
+
+// Set the queue's access policy to the statements, replacing any it had.
+func (q *Queue) SetPolicy(stmts []PolicyStatement) error {
+	policy := Policy{Version: POLICY_VERSION, Statement: make([]PolicyStatement, len(stmts))}
+	for i, stmt := range stmts {
+		if stmt.Effect == "" {
+			stmt.Effect = "Allow"
+		}
+		if stmt.Resource == "" {
+			arn, err := q.ARN()
+			if err != nil {
+				return err
+			}
+			stmt.Resource = arn
+		}
+		policy.Statement[i] = stmt
+	}
+	doc, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	_, err = q.SetQueueAttributes(map[string]string{"Policy": string(doc)})
+	return err
+}
+
+// Get the queue's access policy, or nil if it has none.
+func (q *Queue) GetPolicy() (*Policy, error) {
+	gqaResp, err := q.GetQueueAttributes("Policy")
+	if err != nil {
+		return nil, err
+	}
+	doc := gqaResp.AttributeMap()["Policy"]
+	if doc == "" {
+		return nil, nil
+	}
+	policy := &Policy{}
+	if err = json.Unmarshal([]byte(doc), policy); err != nil {
+		return nil, fmt.Errorf("sqs.GetPolicy: can't parse the queue's policy: %w", err)
+	}
+	return policy, nil
+}
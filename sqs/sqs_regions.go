@@ -1,14 +1,179 @@
 package sqs
 
-var Regions = map[string]Region{
-	APNortheast.Name:  APNortheast,
-	APSoutheast.Name:  APSoutheast,
-	APSoutheast2.Name: APSoutheast2,
-	EUWest.Name:       EUWest,
-	USEast.Name:       USEast,
-	USWest.Name:       USWest,
-	USWest2.Name:      USWest2,
-	SAEast.Name:       SAEast,
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/p-lewis/awsgolang/auth"
+	"github.com/p-lewis/awsgolang/sign4"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The regions that can be looked up by name: the pre-defined ones, and any added with RegisterRegion.
+// Guarded by regionsMu, as RegisterRegion may be called while other goroutines look regions up.
+var (
+	regionsMu sync.RWMutex
+	regions   = map[string]Region{
+		APNortheast.Name:  APNortheast,
+		APSoutheast.Name:  APSoutheast,
+		APSoutheast2.Name: APSoutheast2,
+		EUWest.Name:       EUWest,
+		USEast.Name:       USEast,
+		USWest.Name:       USWest,
+		USWest2.Name:      USWest2,
+		SAEast.Name:       SAEast,
+		USGovWest.Name:    USGovWest,
+		CNNorth.Name:      CNNorth,
+	}
+)
+
+// Names of the regions that can be looked up, sorted.
+func RegionNames() []string {
+	regionsMu.RLock()
+	defer regionsMu.RUnlock()
+	names := make([]string, 0, len(regions))
+	for name := range regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Look up a region by name (e.g. "us-west-2"). The Region returned is a copy, which the caller
+// may change.
+func LookupRegion(name string) (*Region, bool) {
+	regionsMu.RLock()
+	region, ok := regions[name]
+	regionsMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return &region, true
+}
+
+// Add a region, e.g. a new one or a VPC endpoint, to those that can be looked up by name. A
+// region with the same name is replaced. Safe to call while other goroutines look up regions.
+func RegisterRegion(region Region) {
+	regionsMu.Lock()
+	defer regionsMu.Unlock()
+	regions[region.Name] = region
+}
+
+// Build a region for an endpoint that isn't pre-defined, e.g. "http://localhost:9324" for a local
+// SQS-compatible server. The endpoint's scheme is kept as is (nothing assumes https); a trailing "/"
+// is dropped, as request paths are added after the endpoint.
+func RegionForEndpoint(name, endpoint string) *Region {
+	return &Region{Name: name, Endpoint: strings.TrimSuffix(endpoint, "/")}
+}
+
+// The FIPS 140-2 validated endpoints of the regions that have them, by region name. That of a GovCloud
+// region is its standard endpoint, which is FIPS validated. Only regions LookupRegion knows are listed.
+// https://aws.amazon.com/compliance/fips/
+var fipsEndpoints = map[string]string{
+	USEast.Name:    "https://sqs-fips.us-east-1.amazonaws.com",
+	USWest.Name:    "https://sqs-fips.us-west-1.amazonaws.com",
+	USWest2.Name:   "https://sqs-fips.us-west-2.amazonaws.com",
+	USGovWest.Name: "https://sqs.us-gov-west-1.amazonaws.com",
+}
+
+// Returned, wrapped, by LookupFIPSRegion for a region without a FIPS endpoint.
+var ErrNoFIPSEndpoint = errors.New("sqs: region has no FIPS endpoint")
+
+// Look up a region by name, as LookupRegion does, switching it to its FIPS endpoint if fips is set;
+// requests are still signed for the region's name. If fips is set, a region without a FIPS endpoint is
+// an error wrapping ErrNoFIPSEndpoint, rather than one silently using its standard endpoint.
+func LookupFIPSRegion(name string, fips bool) (*Region, error) {
+	region, ok := LookupRegion(name)
+	if !ok {
+		return nil, fmt.Errorf("sqs.LookupFIPSRegion: Unknown region %q", name)
+	}
+	if !fips {
+		return region, nil
+	}
+	endpoint, ok := fipsEndpoints[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoFIPSEndpoint, name)
+	}
+	region.Endpoint = endpoint
+	return region, nil
+}
+
+// How long NewNearest waits for any candidate region to answer its probe.
+const NEAREST_PROBE_TIMEOUT = 2 * time.Second
+
+// Build an SQS client for whichever of the candidate regions (names that LookupRegion knows, e.g.
+// "us-west-2") is nearest: the endpoints are probed together, with a TCP connection to each, and the
+// first to connect wins. If none connects within NEAREST_PROBE_TIMEOUT, the first candidate is used,
+// so put the preferred default first. The probe is made once, here, and needs no credentials.
+func NewNearest(cred *auth.Credentials, candidates []string) (*SQS, error) {
+	if len(candidates) == 0 {
+		return nil, errors.New("sqs.NewNearest: No candidate regions")
+	}
+	regions := make([]*Region, len(candidates))
+	for i, name := range candidates {
+		region, ok := LookupRegion(name)
+		if !ok {
+			return nil, fmt.Errorf("sqs.NewNearest: Unknown region %q", name)
+		}
+		regions[i] = region
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), NEAREST_PROBE_TIMEOUT)
+	defer cancel()
+	probed := make(chan *Region, len(regions)) // nil for a failed probe
+	for _, region := range regions {
+		go func(region *Region) {
+			if probeRegion(ctx, region) != nil {
+				region = nil
+			}
+			probed <- region
+		}(region)
+	}
+	nearest := regions[0]
+	for range regions {
+		if region := <-probed; region != nil {
+			nearest = region
+			break
+		}
+	}
+	return &SQS{Credentials: cred, Region: nearest, ClientFactory: DefaultClientFactory}, nil
+}
+
+// Open, and close, a TCP connection to the region's endpoint.
+func probeRegion(ctx context.Context, region *Region) error {
+	u, err := url.Parse(region.Endpoint)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Get the ARN partition of a region: "aws-us-gov" for GovCloud, "aws-cn" for China, otherwise "aws".
+func partition(regionName string) string {
+	switch {
+	case strings.HasPrefix(regionName, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(regionName, "cn-"):
+		return "aws-cn"
+	}
+	return "aws"
 }
 
 // Pre-defined regions
@@ -53,3 +218,20 @@ var SAEast = Region{
 	"sa-east-1",
 	"https://sqs.sa-east-1.amazonaws.com",
 }
+
+var USGovWest = Region{
+	"us-gov-west-1",
+	"https://sqs.us-gov-west-1.amazonaws.com",
+}
+
+var CNNorth = Region{
+	"cn-north-1",
+	"https://sqs.cn-north-1.amazonaws.com.cn",
+}
+
+// The legacy global endpoint, which some older queue URLs still use. Requests to it are signed
+// for us-east-1 (sign4.GlobalRegion). It can't be looked up, as it shares its name with USEast.
+var Legacy = Region{
+	sign4.GlobalRegion,
+	"https://queue.amazonaws.com",
+}
@@ -1,20 +1,33 @@
 package sqs_test
 
 import (
-	//"fmt"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	. "launchpad.net/gocheck"
 	"testing"
 
 	// "bufio"
-	// "bytes"
-	// "errors"
 	"flag"
 	"github.com/p-lewis/awsgolang/auth"
+	"github.com/p-lewis/awsgolang/awsclient"
+	"github.com/p-lewis/awsgolang/sign4"
 	"github.com/p-lewis/awsgolang/sqs"
-	// "io/ioutil"
-	// "net/http"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
 	// "path/filepath"
-	// "strings"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,22 +35,2218 @@ import (
 
 func Test(t *testing.T) { TestingT(t) }
 
+// Unit tests, run against a local httptest server.
 type SQSSuite struct {
-	// request1 *sign4.ReusableRequest
-	// request2 *sign4.ReusableRequest
+	server  *httptest.Server
+	handler http.HandlerFunc // handles requests to server for the current test
 }
 
 var _ = Suite(&SQSSuite{})
 
-var testRegion = &sqs.Region{Name: "test-region", Endpoint: "http://localhost:6924/testendpoint"}
 var testCredentials = &auth.Credentials{AccessKey: "WHOAMI", SecretKey: "ITSASECRET"}
-var testSQS = sqs.SQS{testCredentials, testRegion, sqs.DefaultClientFactory}
 
 const QUEUE_NAME_PREFIX = "Test_sqs_test_"
 
-// func (s *SQSSuite) TestCreateQueue(c *C) {
-// 	testSQS.CreateQueue("TestQueue")
-// }
+func (s *SQSSuite) SetUpSuite(c *C) {
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handler(w, r)
+	}))
+}
+
+func (s *SQSSuite) TearDownSuite(c *C) {
+	s.server.Close()
+}
+
+func (s *SQSSuite) SetUpTest(c *C) {
+	s.handler = respondWith(http.StatusInternalServerError, "no handler set for test")
+}
+
+// Build an SQS for the local test server.
+func (s *SQSSuite) testSQS() *sqs.SQS {
+	region := &sqs.Region{Name: "test-region", Endpoint: s.server.URL}
+	return &sqs.SQS{Credentials: testCredentials, Region: region, ClientFactory: sqs.DefaultClientFactory}
+}
+
+// Build a handler that always responds with the given status and body.
+func respondWith(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+// Build a handler that stores the request's parameters (query and form) in *params, and responds with
+// the given status and body.
+func recordParams(params *url.Values, status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*params = r.Form
+		respondWith(status, body)(w, r)
+	}
+}
+
+// Build a Queue on the local test server.
+func (s *SQSSuite) testQueue(name string) *sqs.Queue {
+	return &sqs.Queue{SQS: s.testSQS(), Name: name, Url: s.server.URL + "/123456789012/" + name}
+}
+
+const listQueuesXML = `<ListQueuesResponse xmlns="http://queue.amazonaws.com/doc/2012-11-05/">
+  <ListQueuesResult>
+    <QueueUrl>http://sqs.test-region.amazonaws.com/123456789012/Test_sqs_test_one</QueueUrl>
+    <QueueUrl>http://sqs.test-region.amazonaws.com/123456789012/Test_sqs_test_two</QueueUrl>
+  </ListQueuesResult>
+  <ResponseMetadata>
+    <RequestId>725275ae-0b9b-4762-b238-436d7c65a1ac</RequestId>
+  </ResponseMetadata>
+</ListQueuesResponse>`
+
+func (s *SQSSuite) TestListQueues(c *C) {
+	s.handler = respondWith(http.StatusOK, listQueuesXML)
+	queues, lqResp, err := s.testSQS().ListQueues(QUEUE_NAME_PREFIX)
+	c.Assert(err, IsNil)
+	c.Assert(lqResp.StatusCode, Equals, 200)
+	c.Assert(lqResp.RequestId, Equals, "725275ae-0b9b-4762-b238-436d7c65a1ac")
+	c.Assert(len(queues), Equals, 2)
+	c.Assert(queues[0].Name, Equals, "Test_sqs_test_one")
+	c.Assert(queues[1].Url, Equals, "http://sqs.test-region.amazonaws.com/123456789012/Test_sqs_test_two")
+}
+
+func (s *SQSSuite) TestSentQueryIsCanonical(c *C) {
+	var rawQuery string
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		rawQuery = r.URL.RawQuery
+		respondWith(http.StatusOK, receiveMessageXML)(w, r)
+	}
+	names := []string{"b attr", "a*attr~", "C+attr", "z", "y", "x", "w", "v", "u", "t", "s"}
+	_, err := s.testQueue("TestQueue").ReceiveMessage(&sqs.ReceiveMessageOptions{MessageAttributeNames: names})
+	c.Assert(err, IsNil)
+	parsed, err := url.ParseQuery(rawQuery)
+	c.Assert(err, IsNil)
+	canonical, err := sign4.CanonicalQueryString(parsed)
+	c.Assert(err, IsNil)
+	c.Assert(rawQuery, Equals, canonical)
+	c.Assert(strings.Contains(rawQuery, "&MessageAttributeName.1=b%20attr&MessageAttributeName.10=t&"), Equals, true)
+}
+
+// Check the signature of a request received from an SQS with testCredentials, as SQS would: rebuild
+// the canonical request from what was received, and sign it again.
+func verifySignature(c *C, r *http.Request) {
+	authz := regexp.MustCompile("^AWS4-HMAC-SHA256 Credential=[^/]+/([^,]+), SignedHeaders=([^,]+), Signature=([0-9a-f]+)$").
+		FindStringSubmatch(r.Header.Get("Authorization"))
+	c.Assert(authz, NotNil)
+	scope, signedHeaders, signature := authz[1], authz[2], authz[3]
+
+	received, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	c.Assert(err, IsNil)
+	for _, label := range strings.Split(signedHeaders, ";") {
+		if label != "host" {
+			received.Header[http.CanonicalHeaderKey(label)] = r.Header[http.CanonicalHeaderKey(label)]
+		}
+	}
+	cr, err := sign4.CanonicalRequestFromHTTP(received, "")
+	c.Assert(err, IsNil)
+	c.Assert(cr.Headers, Equals, signedHeaders)
+	t, err := time.Parse(sign4.FMT_AMZN_DATE, r.Header.Get("X-Amz-Date"))
+	c.Assert(err, IsNil)
+	expect, err := sign4.SignStringToSign(sign4.StringToSign(cr.CanonicalRequest, scope, t), testCredentials.SecretKey)
+	c.Assert(err, IsNil)
+	c.Assert(signature, Equals, expect)
+}
+
+func (s *SQSSuite) TestHost(c *C) {
+	var received *http.Request
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		verifySignature(c, r)
+		respondWith(http.StatusOK, listQueuesXML)(w, r)
+	}
+	sqsClient := s.testSQS()
+	sqsClient.Host = "sqs.test-region.amazonaws.com"
+	_, _, err := sqsClient.ListQueues("")
+	c.Assert(err, IsNil)
+	// the signature is for the Host header, not the address connected to
+	c.Assert(received.Host, Equals, "sqs.test-region.amazonaws.com")
+}
+
+func (s *SQSSuite) TestDryRun(c *C) {
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		c.Errorf("dry run request sent: %v", r.URL)
+	}
+	sqsClient := s.testSQS()
+	sqsClient.DryRun = true
+	c.Assert(sqsClient.LastRequest(), IsNil)
+	q := &sqs.Queue{SQS: sqsClient, Name: "TestQueue", Url: s.server.URL + "/123456789012/TestQueue"}
+
+	_, err := q.SendMessage("hello", &sqs.SendMessageOptions{DelaySeconds: 5})
+	c.Assert(err, Equals, sqs.ErrDryRun)
+	req := sqsClient.LastRequest()
+	c.Assert(req.Method, Equals, "POST")
+	c.Assert(req.URL.String(), Equals, q.Url+"/")
+	c.Assert(req.Header.Get("Authorization"), Matches, "AWS4-HMAC-SHA256 Credential=[^/]+/[0-9]{8}/test-region/sqs/aws4_request, .*")
+	body, err := ioutil.ReadAll(req.Body)
+	c.Assert(err, IsNil)
+	params, err := url.ParseQuery(string(body))
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("Action"), Equals, "SendMessage")
+	c.Assert(params.Get("MessageBody"), Equals, "hello")
+	c.Assert(params.Get("DelaySeconds"), Equals, "5")
+
+	_, err = q.GetQueueAttributes("QueueArn")
+	c.Assert(err, Equals, sqs.ErrDryRun)
+	req = sqsClient.LastRequest()
+	c.Assert(req.Method, Equals, "GET")
+	c.Assert(req.URL.Query().Get("Action"), Equals, "GetQueueAttributes")
+	c.Assert(req.URL.Query().Get("AttributeName.1"), Equals, "QueueArn")
+	c.Assert(req.Header.Get("Authorization"), Matches, ".*, SignedHeaders=host;user-agent;x-amz-date, Signature=[0-9a-f]{64}")
+}
+
+func (s *SQSSuite) TestDeleteMessageReceiptHandle(c *C) {
+	handle := "MbZj6wDWli+JvwwJaBV+3dcjk2YW2vA3+STFFljTM8tJJg6HRG6PYSasuWXPJB+CwLj1FjgXUv1uSj1gUPAWV66FU/WeR4mq2OKpEGYWbnLmpRCJVAyeMjeU5ZBdtcQ+QEauMZc8ZRv37sIW2iJKq3M9MFx1YvV11A2x/KSbkJ0="
+	var received *http.Request
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		received = r
+		respondWith(http.StatusOK, "<DeleteMessageResponse/>")(w, r)
+	}
+	_, err := s.testQueue("TestQueue").DeleteMessage(handle)
+	c.Assert(err, IsNil)
+	c.Assert(received.Form.Get("Action"), Equals, "DeleteMessage")
+	c.Assert(received.Form.Get("ReceiptHandle"), Equals, handle)
+	c.Assert(strings.Contains(received.URL.RawQuery, "ReceiptHandle=MbZj6wDWli%2BJvwwJaBV%2B"), Equals, true)
+	c.Assert(strings.HasSuffix(received.URL.RawQuery, "x%2FKSbkJ0%3D&Version=2012-11-05"), Equals, true)
+	verifySignature(c, received)
+}
+
+func (s *SQSSuite) TestListQueuesRawResponseOnlyWithDebug(c *C) {
+	s.handler = respondWith(http.StatusOK, listQueuesXML)
+	testSQS := s.testSQS()
+	_, lqResp, err := testSQS.ListQueues("")
+	c.Assert(err, IsNil)
+	c.Assert(lqResp.RawResponse, IsNil)
+
+	testSQS.Debug = true
+	_, lqResp, err = testSQS.ListQueues("")
+	c.Assert(err, IsNil)
+	c.Assert(string(lqResp.RawResponse), Equals, listQueuesXML)
+}
+
+const accessDeniedXML = `<ErrorResponse xmlns="http://queue.amazonaws.com/doc/2012-11-05/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>AccessDenied</Code>
+    <Message>Access to the resource is denied.</Message>
+    <Detail/>
+  </Error>
+  <RequestId>c1d3a1f2-8ffc-5e7f-9a0e-7d6e2c1f0a11</RequestId>
+</ErrorResponse>`
+
+const noRequestIdXML = `<ErrorResponse xmlns="http://queue.amazonaws.com/doc/2012-11-05/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>AccessDenied</Code>
+    <Message>Access to the resource is denied.</Message>
+  </Error>
+</ErrorResponse>`
+
+// An error in the <Response><Errors><Error> shape some services use.
+type errorsResponse struct {
+	XMLName   xml.Name        `xml:"Response"`
+	Errors    []sqs.ErrorInfo `xml:"Errors>Error"`
+	RequestID string
+	sqs.AWSResponse
+}
+
+func (e *errorsResponse) Error() string {
+	return "errorsResponse: " + e.Errors[0].Code
+}
+
+const errorsResponseXML = `<Response>
+  <Errors>
+    <Error>
+      <Code>AuthFailure</Code>
+      <Message>AWS was not able to validate the provided access credentials</Message>
+    </Error>
+  </Errors>
+  <RequestID>b25f48e8-84fd-11e6-80d1-0242ac120002</RequestID>
+</Response>`
+
+func (s *SQSSuite) TestNewErrorResponse(c *C) {
+	s.handler = respondWith(http.StatusUnauthorized, errorsResponseXML)
+	queue := s.testQueue("TestQueue")
+	queue.NewErrorResponse = func() sqs.BodyUnmarshallerError { return &errorsResponse{} }
+
+	// buffered and streamed responses alike
+	_, err := queue.SendMessage("hello", nil)
+	errResponse, ok := err.(*errorsResponse)
+	c.Assert(ok, Equals, true)
+	c.Assert(errResponse.Errors[0].Code, Equals, "AuthFailure")
+	c.Assert(errResponse.RequestID, Equals, "b25f48e8-84fd-11e6-80d1-0242ac120002")
+	c.Assert(errResponse.StatusCode, Equals, http.StatusUnauthorized)
+
+	_, err = queue.ReceiveMessage(nil)
+	errResponse, ok = err.(*errorsResponse)
+	c.Assert(ok, Equals, true)
+	c.Assert(errResponse.Errors[0].Message, Equals, "AWS was not able to validate the provided access credentials")
+
+	// without it, the shape isn't recognized
+	_, err = s.testQueue("TestQueue").SendMessage("hello", nil)
+	c.Assert(err, ErrorMatches, "(?s)sqs.unmarshalResponse: Unable to unmarshal body data .*")
+}
+
+// An unrecognized body is quoted in the error, but only its start: it's whatever the server sent.
+func (s *SQSSuite) TestUnrecognizedResponseBodyTruncated(c *C) {
+	body := "<html>" + strings.Repeat("x", 10000) + "SECRET-AT-THE-END</html>"
+	s.handler = respondWith(http.StatusBadGateway, body)
+	_, err := s.testQueue("TestQueue").SendMessage("hello", nil)
+	c.Assert(err, ErrorMatches, `sqs.unmarshalResponse: Unable to unmarshal body data .*, body: "<html>x+"\.\.\. \(10030 bytes\)`)
+	c.Assert(strings.Contains(err.Error(), "SECRET-AT-THE-END"), Equals, false)
+	c.Assert(len(err.Error()) < 2*sqs.MAX_ERROR_BODY_BYTES, Equals, true)
+
+	// a short one is quoted whole, on one line
+	s.handler = respondWith(http.StatusBadGateway, "<html>\nBad Gateway</html>")
+	_, err = s.testQueue("TestQueue").SendMessage("hello", nil)
+	c.Assert(err, ErrorMatches, `.*, body: "<html>\\nBad Gateway</html>"`)
+}
+
+func (s *SQSSuite) TestErrorRequestIdFromHeader(c *C) {
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-amzn-RequestId", "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+		respondWith(http.StatusForbidden, noRequestIdXML)(w, r)
+	}
+	// buffered and streamed responses
+	_, err := s.testQueue("TestQueue").SendMessage("hello", nil)
+	c.Assert(err.(*sqs.ErrorResponse).RequestId, Equals, "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+	_, _, err = s.testSQS().ListQueues("")
+	c.Assert(err.(*sqs.ErrorResponse).RequestId, Equals, "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+
+	// the body's ID is preferred
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-amzn-RequestId", "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+		respondWith(http.StatusForbidden, accessDeniedXML)(w, r)
+	}
+	_, err = s.testQueue("TestQueue").SendMessage("hello", nil)
+	c.Assert(err.(*sqs.ErrorResponse).RequestId, Equals, "c1d3a1f2-8ffc-5e7f-9a0e-7d6e2c1f0a11")
+}
+
+func (s *SQSSuite) TestMaxResponseBytes(c *C) {
+	s.handler = respondWith(http.StatusOK, sendMessageXML)
+	queue := s.testQueue("TestQueue")
+	queue.MaxResponseBytes = int64(len(sendMessageXML))
+	_, err := queue.SendMessage("This is a test message", nil)
+	c.Assert(err, IsNil)
+
+	queue.MaxResponseBytes--
+	_, err = queue.SendMessage("This is a test message", nil)
+	c.Assert(err, Equals, sqs.ErrResponseTooLarge)
+
+	// streamed responses are limited too
+	s.handler = respondWith(http.StatusOK, strings.Repeat(" ", 1000)+listQueuesXML)
+	testSQS := s.testSQS()
+	testSQS.MaxResponseBytes = 1000
+	_, _, err = testSQS.ListQueues("")
+	c.Assert(errors.Is(err, sqs.ErrResponseTooLarge), Equals, true)
+}
+
+func (s *SQSSuite) TestListQueuesStreamedError(c *C) {
+	s.handler = respondWith(http.StatusForbidden, accessDeniedXML)
+	queues, lqResp, err := s.testSQS().ListQueues("")
+	c.Assert(queues, IsNil)
+	c.Assert(lqResp, IsNil)
+	errResp, ok := err.(*sqs.ErrorResponse)
+	c.Assert(ok, Equals, true)
+	c.Assert(errResp.Err.Code, Equals, "AccessDenied")
+	c.Assert(errResp.RequestId, Equals, "c1d3a1f2-8ffc-5e7f-9a0e-7d6e2c1f0a11")
+	c.Assert(errResp.StatusCode, Equals, 403)
+}
+
+const sendMessageXML = `<SendMessageResponse>
+  <SendMessageResult>
+    <MD5OfMessageBody>fafb00f5732ab283681e124bf8747ed1</MD5OfMessageBody>
+    <MessageId>5fea7756-0ea4-451a-a703-a558b933e274</MessageId>
+  </SendMessageResult>
+  <ResponseMetadata>
+    <RequestId>27daac76-34dd-47df-bd01-1f6e873584a0</RequestId>
+  </ResponseMetadata>
+</SendMessageResponse>`
+
+const sendMessageFifoXML = `<SendMessageResponse>
+  <SendMessageResult>
+    <MD5OfMessageBody>5d41402abc4b2a76b9719d911017c592</MD5OfMessageBody>
+    <MessageId>a5a7e7b0-8b0e-4c3b-9a5c-3f6c9e2d1b4f</MessageId>
+    <SequenceNumber>18849496460467696128</SequenceNumber>
+  </SendMessageResult>
+  <ResponseMetadata>
+    <RequestId>0c9f7d2e-7a0b-5f3c-8e1d-2b6a4c9e8f01</RequestId>
+  </ResponseMetadata>
+</SendMessageResponse>`
+
+// A LargePayloadStore that keeps the bodies, and sends their index in their place.
+type memoryPayloadStore struct {
+	bodies []string
+}
+
+func (m *memoryPayloadStore) Store(ctx context.Context, q *sqs.Queue, messageBody string) (string, error) {
+	m.bodies = append(m.bodies, messageBody)
+	return fmt.Sprintf(`{"payload": %v}`, len(m.bodies)-1), nil
+}
+
+func (s *SQSSuite) TestSendMessageMaxMessageSize(c *C) {
+	var actions, bodies []string
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		actions = append(actions, r.Form.Get("Action"))
+		if r.Form.Get("Action") == "GetQueueAttributes" {
+			respondWith(http.StatusOK, getQueueAttributesXML)(w, r)
+			return
+		}
+		bodies = append(bodies, r.Form.Get("MessageBody"))
+		respondWith(http.StatusOK, sendMessageXML)(w, r)
+	}
+	queue := s.testQueue("TestQueue")
+	attrs := map[string]sqs.MessageAttributeValue{"a": {DataType: "String", StringValue: "bc"}} // 9 bytes
+	_, err := queue.SendMessage("0123456789", &sqs.SendMessageOptions{MaxMessageSize: 18, MessageAttributes: attrs})
+	c.Assert(errors.Is(err, sqs.ErrMessageTooLarge), Equals, true)
+	c.Assert(err, ErrorMatches, "sqs: message is too large for the queue: 19 bytes, and queue TestQueue takes at most 18")
+	c.Assert(len(actions), Equals, 0)
+	_, err = queue.SendMessage("0123456789", &sqs.SendMessageOptions{MaxMessageSize: 19, MessageAttributes: attrs})
+	c.Assert(err, IsNil)
+	c.Assert(actions, DeepEquals, []string{"SendMessage"})
+
+	// the queue's MaximumMessageSize is 262144
+	actions = nil
+	large := strings.Repeat("x", 262145)
+	_, err = queue.SendMessage(large, &sqs.SendMessageOptions{MaxMessageSize: sqs.FETCH_MAX_MESSAGE_SIZE})
+	c.Assert(errors.Is(err, sqs.ErrMessageTooLarge), Equals, true)
+	c.Assert(actions, DeepEquals, []string{"GetQueueAttributes"})
+
+	store := &memoryPayloadStore{}
+	bodies = nil
+	_, err = queue.SendMessage(large, &sqs.SendMessageOptions{MaxMessageSize: sqs.FETCH_MAX_MESSAGE_SIZE, LargePayloads: store})
+	c.Assert(err, IsNil)
+	c.Assert(store.bodies, DeepEquals, []string{large})
+	c.Assert(bodies, DeepEquals, []string{`{"payload": 0}`})
+}
+
+func (s *SQSSuite) TestSendMessageSequenceNumber(c *C) {
+	s.handler = respondWith(http.StatusOK, sendMessageFifoXML)
+	resp, err := s.testQueue("TestQueue.fifo").SendMessage("hello", &sqs.SendMessageOptions{MessageGroupId: "group1"})
+	c.Assert(err, IsNil)
+	c.Assert(resp.MessageId, Equals, "a5a7e7b0-8b0e-4c3b-9a5c-3f6c9e2d1b4f")
+	c.Assert(resp.SequenceNumber, Equals, "18849496460467696128")
+
+	s.handler = respondWith(http.StatusOK, sendMessageXML)
+	resp, err = s.testQueue("TestQueue").SendMessage("hello", nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.MessageId, Equals, "5fea7756-0ea4-451a-a703-a558b933e274")
+	c.Assert(resp.SequenceNumber, Equals, "")
+}
+
+func (s *SQSSuite) TestSendMessage(c *C) {
+	var params url.Values
+	var method string
+	record := recordParams(&params, http.StatusOK, sendMessageXML)
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		record(w, r)
+	}
+	resp, err := s.testQueue("TestQueue").SendMessage("This is a test message", &sqs.SendMessageOptions{DelaySeconds: 45})
+	c.Assert(err, IsNil)
+	c.Assert(method, Equals, "POST")
+	c.Assert(params.Get("Action"), Equals, "SendMessage")
+	c.Assert(params.Get("MessageBody"), Equals, "This is a test message")
+	c.Assert(params.Get("DelaySeconds"), Equals, "45")
+	c.Assert(resp.MessageId, Equals, "5fea7756-0ea4-451a-a703-a558b933e274")
+	c.Assert(resp.MD5OfMessageBody, Equals, "fafb00f5732ab283681e124bf8747ed1")
+	c.Assert(resp.StatusCode, Equals, 200)
+}
+
+func (s *SQSSuite) TestSendMessageWithContextDeadline(c *C) {
+	release := make(chan bool)
+	handled := make(chan bool)
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		defer close(handled)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	resp, err := s.testQueue("TestQueue").SendMessageWithContext(ctx, "too late", nil)
+	c.Assert(resp, IsNil)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), Equals, true)
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+
+	// the handler must be done before the next test sets its own
+	close(release)
+	<-handled
+}
+
+func (s *SQSSuite) TestDeleteQueuesByPrefix(c *C) {
+	var listPrefix string
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("Action") {
+		case "ListQueues":
+			listPrefix = r.Form.Get("QueueNamePrefix")
+			body := "<ListQueuesResponse><ListQueuesResult>"
+			for _, name := range []string{"Test_one", "Test_two", "Test_three"} {
+				body += "<QueueUrl>" + s.server.URL + "/123456789012/" + name + "</QueueUrl>"
+			}
+			body += "</ListQueuesResult></ListQueuesResponse>"
+			respondWith(http.StatusOK, body)(w, r)
+		case "DeleteQueue":
+			if strings.HasSuffix(r.URL.Path, "/Test_two/") {
+				respondWith(http.StatusForbidden, accessDeniedXML)(w, r)
+				return
+			}
+			respondWith(http.StatusOK, "<DeleteQueueResponse></DeleteQueueResponse>")(w, r)
+		}
+	}
+	deleted, errs := s.testSQS().DeleteQueuesByPrefix("Test_")
+	c.Assert(listPrefix, Equals, "Test_")
+	c.Assert(deleted, DeepEquals, []string{"Test_one", "Test_three"})
+	c.Assert(len(errs), Equals, 1)
+	c.Assert(errs["Test_two"], FitsTypeOf, &sqs.ErrorResponse{})
+}
+
+func (s *SQSSuite) TestListQueuesByTag(c *C) {
+	tags := map[string]string{
+		"Test_one":   "<Tag><Key>team</Key><Value>billing</Value></Tag><Tag><Key>env</Key><Value>prod</Value></Tag>",
+		"Test_two":   "",
+		"Test_three": "<Tag><Key>team</Key><Value>search</Value></Tag>",
+		"Test_four":  "<Tag><Key>team</Key><Value>billing</Value></Tag>",
+	}
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("Action") {
+		case "ListQueues":
+			body := "<ListQueuesResponse><ListQueuesResult>"
+			for _, name := range []string{"Test_one", "Test_two", "Test_three", "Test_four"} {
+				body += "<QueueUrl>" + s.server.URL + "/123456789012/" + name + "</QueueUrl>"
+			}
+			body += "</ListQueuesResult></ListQueuesResponse>"
+			respondWith(http.StatusOK, body)(w, r)
+		case "ListQueueTags":
+			name := strings.Split(r.URL.Path, "/")[2]
+			if name == "Test_two" {
+				respondWith(http.StatusForbidden, accessDeniedXML)(w, r)
+				return
+			}
+			respondWith(http.StatusOK, "<ListQueueTagsResponse><ListQueueTagsResult>"+tags[name]+
+				"</ListQueueTagsResult></ListQueueTagsResponse>")(w, r)
+		}
+	}
+	queues, errs := s.testSQS().ListQueuesByTag("team", "billing")
+	c.Assert(queues, HasLen, 2)
+	c.Assert(queues[0].Name, Equals, "Test_four")
+	c.Assert(queues[1].Name, Equals, "Test_one")
+	c.Assert(len(errs), Equals, 1)
+	c.Assert(errs["Test_two"], FitsTypeOf, &sqs.ErrorResponse{})
+
+	s.handler = respondWith(http.StatusForbidden, accessDeniedXML)
+	queues, errs = s.testSQS().ListQueuesByTag("team", "billing")
+	c.Assert(queues, HasLen, 0)
+	c.Assert(errs[""], NotNil)
+}
+
+func (s *SQSSuite) TestListQueueTags(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, "<ListQueueTagsResponse><ListQueueTagsResult>"+
+		"<Tag><Key>team</Key><Value>billing</Value></Tag></ListQueueTagsResult></ListQueueTagsResponse>")
+	resp, err := s.testQueue("TestQueue").ListQueueTags()
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("Action"), Equals, "ListQueueTags")
+	c.Assert(resp.Tags, DeepEquals, []sqs.Tag{{Key: "team", Value: "billing"}})
+	value, ok := resp.Tag("team")
+	c.Assert(ok, Equals, true)
+	c.Assert(value, Equals, "billing")
+	_, ok = resp.Tag("env")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *SQSSuite) TestDeleteQueuesByPrefixNeedsPrefix(c *C) {
+	deleted, errs := s.testSQS().DeleteQueuesByPrefix("")
+	c.Assert(deleted, HasLen, 0)
+	c.Assert(errs[""], ErrorMatches, ".*prefix must not be empty")
+}
+
+func (s *SQSSuite) TestRedirectToOtherRegion(c *C) {
+	var authz []string
+	var params url.Values
+	record := recordParams(&params, http.StatusOK, sendMessageXML)
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		authz = append(authz, r.Header.Get("Authorization"))
+		if !strings.HasPrefix(r.URL.Path, "/moved/") {
+			w.Header().Set("Location", s.server.URL+"/moved/123456789012/TestQueue/")
+			w.Header().Set("x-amz-bucket-region", "eu-west-1")
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		record(w, r)
+	}
+	resp, err := s.testQueue("TestQueue").SendMessage("This is a test message", nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.MessageId, Equals, "5fea7756-0ea4-451a-a703-a558b933e274")
+	c.Assert(len(authz), Equals, 2)
+	c.Assert(authz[0], Matches, ".*/test-region/sqs/aws4_request, .*")
+	c.Assert(authz[1], Matches, ".*/eu-west-1/sqs/aws4_request, .*")
+	c.Assert(params.Get("MessageBody"), Equals, "This is a test message")
+}
+
+func (s *SQSSuite) TestRedirectLoop(c *C) {
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", s.server.URL+"/again/")
+		w.Header().Set("x-amz-bucket-region", "eu-west-1")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}
+	_, err := s.testQueue("TestQueue").SendMessage("This is a test message", nil)
+	_, ok := err.(*awsclient.RedirectError)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *SQSSuite) TestLogger(c *C) {
+	s.handler = respondWith(http.StatusOK, sendMessageXML)
+	buf := new(bytes.Buffer)
+	queue := s.testQueue("TestQueue")
+	queue.Logger = log.New(buf, "", 0)
+	_, err := queue.SendMessage("This is a test message", nil)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Matches, "(?s)sign4: credential scope: [0-9]{8}/test-region/sqs/aws4_request\n"+
+		"canonical request:\nPOST\n/123456789012/TestQueue/\n.*string to sign:\nAWS4-HMAC-SHA256\n.*")
+}
+
+func (s *SQSSuite) TestRetryServerError(c *C) {
+	attempts := 0
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			respondWith(http.StatusServiceUnavailable, "try again")(w, r)
+			return
+		}
+		respondWith(http.StatusOK, sendMessageXML)(w, r)
+	}
+	queue := s.testQueue("TestQueue")
+	queue.MaxRetries = 2
+	resp, err := queue.SendMessage("This is a test message", nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Assert(attempts, Equals, 3)
+	c.Assert(resp.RequestInfo.Attempts, Equals, 3)
+	c.Assert(resp.RequestInfo.Elapsed >= sqs.RETRY_BASE_DELAY, Equals, true)
+}
+
+const internalErrorXML = `<ErrorResponse xmlns="http://queue.amazonaws.com/doc/2012-11-05/">
+  <Error>
+    <Type>Receiver</Type>
+    <Code>InternalError</Code>
+    <Message>We encountered an internal error. Please try again.</Message>
+  </Error>
+  <RequestId>9c5a4b1e-2d7f-5e0a-8b3c-6f1d2e3a4b5c</RequestId>
+</ErrorResponse>`
+
+func (s *SQSSuite) TestRetryByFaultType(c *C) {
+	attempts := 0
+	status, body := http.StatusBadRequest, internalErrorXML
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			respondWith(status, body)(w, r)
+			return
+		}
+		respondWith(http.StatusOK, sendMessageXML)(w, r)
+	}
+	queue := s.testQueue("TestQueue")
+	queue.MaxRetries = 1
+
+	// a Receiver fault is retried, whatever its status
+	_, err := queue.SendMessage("hello", nil)
+	c.Assert(err, IsNil)
+	c.Assert(attempts, Equals, 2)
+
+	// a Sender fault isn't, even with a 5xx
+	attempts = 0
+	status, body = http.StatusServiceUnavailable, accessDeniedXML
+	_, err = queue.SendMessage("hello", nil)
+	errResponse, ok := err.(*sqs.ErrorResponse)
+	c.Assert(ok, Equals, true)
+	c.Assert(errResponse.IsClientError(), Equals, true)
+	c.Assert(errResponse.IsServerError(), Equals, false)
+	c.Assert(errResponse.Err.Code, Equals, "AccessDenied") // the body is still read for the error
+	c.Assert(attempts, Equals, 1)
+}
+
+func (s *SQSSuite) TestErrorResponseFaultType(c *C) {
+	receiver := &sqs.ErrorResponse{Err: sqs.ErrorInfo{Type: "Receiver"}}
+	c.Assert(receiver.IsServerError(), Equals, true)
+	c.Assert(receiver.IsClientError(), Equals, false)
+
+	// without a Type, the status decides
+	untyped := &sqs.ErrorResponse{}
+	untyped.StatusCode = http.StatusBadGateway
+	c.Assert(untyped.IsServerError(), Equals, true)
+	c.Assert(untyped.IsClientError(), Equals, false)
+	untyped.StatusCode = http.StatusNotFound
+	c.Assert(untyped.IsServerError(), Equals, false)
+	c.Assert(untyped.IsClientError(), Equals, true)
+}
+
+func (s *SQSSuite) TestRequestInfo(c *C) {
+	s.handler = respondWith(http.StatusOK, receiveMessagesXML("1"))
+	rmResp, err := s.testQueue("TestQueue").ReceiveMessage(nil)
+	c.Assert(err, IsNil)
+	c.Assert(rmResp.RequestInfo.Attempts, Equals, 1)
+	c.Assert(rmResp.RequestInfo.Elapsed > 0, Equals, true)
+
+	s.handler = respondWith(http.StatusForbidden, accessDeniedXML)
+	_, err = s.testQueue("TestQueue").SendMessage("hello", nil)
+	errResponse, ok := err.(*sqs.ErrorResponse)
+	c.Assert(ok, Equals, true)
+	c.Assert(errResponse.RequestInfo.Attempts, Equals, 1)
+}
+
+func (s *SQSSuite) TestWithMaxRetries(c *C) {
+	attempts := 0
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		respondWith(http.StatusServiceUnavailable, "try again")(w, r)
+	}
+	queue := s.testQueue("TestQueue")
+	queue.MaxRetries = 2
+	_, err := queue.SendMessageWithContext(sqs.WithMaxRetries(context.Background(), 0), "hello", nil)
+	c.Assert(err, NotNil)
+	c.Assert(attempts, Equals, 1)
+
+	attempts = 0
+	queue.MaxRetries = 0
+	_, err = queue.ReceiveMessageWithContext(sqs.WithMaxRetries(context.Background(), 1), nil)
+	c.Assert(err, NotNil)
+	c.Assert(attempts, Equals, 2)
+
+	// calls without it use MaxRetries
+	attempts = 0
+	queue.MaxRetries = 1
+	_, err = queue.SendMessageWithContext(context.Background(), "hello", nil)
+	c.Assert(err, NotNil)
+	c.Assert(attempts, Equals, 2)
+}
+
+func (s *SQSSuite) TestNoRetryByDefault(c *C) {
+	attempts := 0
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		respondWith(http.StatusServiceUnavailable, "try again")(w, r)
+	}
+	_, err := s.testQueue("TestQueue").SendMessage("This is a test message", nil)
+	c.Assert(err, NotNil)
+	c.Assert(attempts, Equals, 1)
+}
+
+func (s *SQSSuite) TestSendMessageAt(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, sendMessageXML)
+	now := time.Date(2014, time.March, 1, 12, 0, 0, 0, time.UTC)
+	queue := s.testQueue("TestQueue")
+	queue.Now = func() time.Time { return now }
+
+	// rounded up, so it's not delivered early; the caller's options are left alone
+	opts := &sqs.SendMessageOptions{DelaySeconds: 5}
+	_, err := queue.SendMessageAt("hello", now.Add(90*time.Second+time.Millisecond), opts)
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("DelaySeconds"), Equals, "91")
+	c.Assert(opts.DelaySeconds, Equals, 5)
+
+	_, err = queue.SendMessageAt("hello", now.Add(15*time.Minute), nil)
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("DelaySeconds"), Equals, "900")
+
+	// in the past: no delay, not even the one in opts
+	_, err = queue.SendMessageAt("hello", now.Add(-time.Minute), opts)
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("DelaySeconds"), Equals, "")
+
+	params = nil
+	_, err = queue.SendMessageAt("hello", now.Add(15*time.Minute+time.Second), nil)
+	c.Assert(err, ErrorMatches, "sqs.SendMessageAt: .* is 15m1s away, more than the longest delay of 900 seconds")
+	c.Assert(params, IsNil)
+}
+
+func (s *SQSSuite) TestSendMessageFifoDelay(c *C) {
+	requests := 0
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		respondWith(http.StatusOK, sendMessageXML)(w, r)
+	}
+	opts := &sqs.SendMessageOptions{DelaySeconds: 30, MessageGroupId: "group1"}
+	_, err := s.testQueue("TestQueue.fifo").SendMessage("hello", opts)
+	c.Assert(err, ErrorMatches, "sqs: FIFO queue TestQueue.fifo doesn't allow a per-message DelaySeconds .*")
+	_, err = s.testQueue("TestQueue.fifo").SendMessageBatch([]sqs.BatchSendEntry{
+		{Id: "ok", MessageBody: "hello"}, {Id: "delayed", MessageBody: "hello", SendMessageOptions: *opts}})
+	c.Assert(err, ErrorMatches, ".*per-message DelaySeconds.* \\(entry delayed\\)")
+	c.Assert(requests, Equals, 0)
+
+	// a standard queue allows it, and a FIFO queue allows no delay
+	_, err = s.testQueue("TestQueue").SendMessage("hello", opts)
+	c.Assert(err, IsNil)
+	_, err = s.testQueue("TestQueue.fifo").SendMessage("hello", &sqs.SendMessageOptions{MessageGroupId: "group1"})
+	c.Assert(err, IsNil)
+	c.Assert(requests, Equals, 2)
+	c.Assert(s.testQueue("TestQueue.fifo").IsFifo(), Equals, true)
+	c.Assert(s.testQueue("TestQueue").IsFifo(), Equals, false)
+}
+
+func (s *SQSSuite) TestSendMessageIdempotent(c *C) {
+	var dedupIds []string
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		dedupIds = append(dedupIds, r.Form.Get("MessageDeduplicationId"))
+		if len(dedupIds) == 1 {
+			respondWith(http.StatusInternalServerError, "oops")(w, r)
+			return
+		}
+		respondWith(http.StatusOK, sendMessageXML)(w, r)
+	}
+	queue := s.testQueue("TestQueue.fifo")
+	queue.MaxRetries = 1
+	_, err := queue.SendMessageIdempotent("hello", sqs.SendMessageOptions{MessageGroupId: "group1"})
+	c.Assert(err, IsNil)
+	c.Assert(len(dedupIds), Equals, 2)
+	c.Assert(dedupIds[0], Not(Equals), "")
+	c.Assert(dedupIds[1], Equals, dedupIds[0])
+
+	// the same body and group always get the same ID; a different group gets another
+	_, err = queue.SendMessageIdempotent("hello", sqs.SendMessageOptions{MessageGroupId: "group1"})
+	c.Assert(err, IsNil)
+	c.Assert(dedupIds[2], Equals, dedupIds[0])
+	_, err = queue.SendMessageIdempotent("hello", sqs.SendMessageOptions{MessageGroupId: "group2"})
+	c.Assert(err, IsNil)
+	c.Assert(dedupIds[3], Not(Equals), dedupIds[0])
+
+	// a caller's ID is used as is
+	_, err = queue.SendMessageIdempotent("hello", sqs.SendMessageOptions{MessageGroupId: "group1", MessageDeduplicationId: "mine"})
+	c.Assert(err, IsNil)
+	c.Assert(dedupIds[4], Equals, "mine")
+}
+
+func (s *SQSSuite) TestSendMessageIdempotentNeedsGroup(c *C) {
+	_, err := s.testQueue("TestQueue.fifo").SendMessageIdempotent("hello", sqs.SendMessageOptions{})
+	c.Assert(err, ErrorMatches, ".*MessageGroupId is required")
+}
+
+const receiveMessageXML = `<ReceiveMessageResponse>
+  <ReceiveMessageResult>
+    <Message>
+      <MessageId>5fea7756-0ea4-451a-a703-a558b933e274</MessageId>
+      <ReceiptHandle>MbZj6wDWli+JvwwJaBV+3dcjk2YW2vA3+STFFljTM8tJJg6HRG6PYSasuWXPJB+CwLj1FjgXUv1uSj1gUPAWV66FU/WeR4mq2OKpEGYWbnLmpRCJVAyeMjeU5ZBdtcQ+QEauMZc8ZRv37sIW2iJKq3M9MFx1YvV11A2x/KSbkJ0=</ReceiptHandle>
+      <MD5OfBody>fafb00f5732ab283681e124bf8747ed1</MD5OfBody>
+      <Body>This is a test message</Body>
+      <Attribute>
+        <Name>SenderId</Name>
+        <Value>195004372649</Value>
+      </Attribute>
+    </Message>
+  </ReceiveMessageResult>
+  <ResponseMetadata>
+    <RequestId>b6633655-283d-45b4-aee4-4e84e0ae6afa</RequestId>
+  </ResponseMetadata>
+</ReceiveMessageResponse>`
+
+const receiveNoMessagesXML = `<ReceiveMessageResponse>
+  <ReceiveMessageResult/>
+  <ResponseMetadata>
+    <RequestId>b6633655-283d-45b4-aee4-4e84e0ae6afa</RequestId>
+  </ResponseMetadata>
+</ReceiveMessageResponse>`
+
+func (s *SQSSuite) TestReceiveMessage(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, receiveMessageXML)
+	resp, err := s.testQueue("TestQueue").ReceiveMessage(&sqs.ReceiveMessageOptions{MaxNumberOfMessages: 5, VisibilityTimeout: 60})
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("Action"), Equals, "ReceiveMessage")
+	c.Assert(params.Get("MaxNumberOfMessages"), Equals, "5")
+	c.Assert(params.Get("VisibilityTimeout"), Equals, "60")
+	c.Assert(params.Get("WaitTimeSeconds"), Equals, "")
+	c.Assert(resp.Empty(), Equals, false)
+	c.Assert(resp.Messages, HasLen, 1)
+	msg := resp.Messages[0]
+	c.Assert(msg.MessageId, Equals, "5fea7756-0ea4-451a-a703-a558b933e274")
+	c.Assert(msg.Body, Equals, "This is a test message")
+	c.Assert(msg.MD5OfBody, Equals, "fafb00f5732ab283681e124bf8747ed1")
+	c.Assert(msg.Attributes, DeepEquals, []sqs.Attribute{{Name: "SenderId", Value: "195004372649"}})
+}
+
+func (s *SQSSuite) TestReceiveMessageReceiveCount(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, strings.Replace(receiveMessageXML, "</Attribute>",
+		"</Attribute><Attribute><Name>ApproximateReceiveCount</Name><Value>3</Value></Attribute>", 1))
+	resp, err := s.testQueue("TestQueue").ReceiveMessage(nil)
+	c.Assert(err, IsNil)
+	c.Assert(params["AttributeName.1"], DeepEquals, []string{"ApproximateReceiveCount"})
+	c.Assert(params.Get("AttributeName.2"), Equals, "")
+	c.Assert(resp.Messages[0].ReceiveCount, Equals, 3)
+	count, ok := resp.Messages[0].Attribute(sqs.RECEIVE_COUNT_ATTRIBUTE)
+	c.Assert(ok, Equals, true)
+	c.Assert(count, Equals, "3")
+
+	// absent
+	s.handler = recordParams(&params, http.StatusOK, receiveMessageXML)
+	resp, err = s.testQueue("TestQueue").ReceiveMessage(&sqs.ReceiveMessageOptions{
+		AttributeNames: []string{"SentTimestamp", "ApproximateReceiveCount"}})
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("AttributeName.1"), Equals, "ApproximateReceiveCount")
+	c.Assert(params.Get("AttributeName.2"), Equals, "SentTimestamp")
+	c.Assert(params.Get("AttributeName.3"), Equals, "")
+	c.Assert(resp.Messages[0].ReceiveCount, Equals, 0)
+
+	// All includes the receive count
+	_, err = s.testQueue("TestQueue").ReceiveMessage(&sqs.ReceiveMessageOptions{AttributeNames: []string{"SentTimestamp", "All"}})
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("AttributeName.1"), Equals, "All")
+	c.Assert(params.Get("AttributeName.2"), Equals, "")
+}
+
+func (s *SQSSuite) TestDrain(c *C) {
+	var deleted []string
+	// two batches, an empty receive, another message (SQS had only sampled some servers), then empties
+	responses := []string{receiveMessagesXML("1", "2"), receiveMessagesXML("3"), receiveNoMessagesXML,
+		receiveMessagesXML("4"), receiveNoMessagesXML, receiveNoMessagesXML, receiveMessagesXML("never")}
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") == "DeleteMessage" {
+			deleted = append(deleted, r.Form.Get("ReceiptHandle"))
+			respondWith(http.StatusOK, "<DeleteMessageResponse/>")(w, r)
+			return
+		}
+		c.Assert(r.Form.Get("MaxNumberOfMessages"), Equals, "10")
+		c.Assert(r.Form.Get("WaitTimeSeconds"), Equals, "1")
+		respondWith(http.StatusOK, responses[0])(w, r)
+		responses = responses[1:]
+	}
+	drained, err := s.testQueue("TestQueue").Drain(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(drained, Equals, 4)
+	c.Assert(deleted, DeepEquals, []string{"rh-1", "rh-2", "rh-3", "rh-4"})
+	c.Assert(responses, HasLen, 1)
+
+	// a failed delete stops it, with the count so far
+	responses = []string{receiveMessagesXML("1", "2")}
+	deleted = nil
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") == "DeleteMessage" {
+			deleted = append(deleted, r.Form.Get("ReceiptHandle"))
+			if len(deleted) == 2 {
+				respondWith(http.StatusBadRequest, accessDeniedXML)(w, r)
+				return
+			}
+			respondWith(http.StatusOK, "<DeleteMessageResponse/>")(w, r)
+			return
+		}
+		respondWith(http.StatusOK, responses[0])(w, r)
+	}
+	drained, err = s.testQueue("TestQueue").Drain(context.Background())
+	c.Assert(err, ErrorMatches, ".*AccessDenied.*")
+	c.Assert(drained, Equals, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.testQueue("TestQueue").Drain(ctx)
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+}
+
+func (s *SQSSuite) TestMessageRemainingVisibility(c *C) {
+	s.handler = respondWith(http.StatusOK, receiveMessageXML)
+	before := time.Now()
+	resp, err := s.testQueue("TestQueue").ReceiveMessage(&sqs.ReceiveMessageOptions{VisibilityTimeout: 60})
+	c.Assert(err, IsNil)
+	msg := resp.Messages[0]
+	c.Assert(msg.VisibilityTimeout, Equals, 60)
+	c.Assert(msg.ReceivedAt.Before(before), Equals, false)
+	c.Assert(msg.VisibilityDeadline(msg.ReceivedAt, 60), Equals, msg.ReceivedAt.Add(time.Minute))
+	remaining := msg.RemainingVisibility()
+	c.Assert(remaining > 0 && remaining <= time.Minute, Equals, true)
+
+	// past the deadline
+	msg.ReceivedAt = time.Now().Add(-61 * time.Second)
+	c.Assert(msg.RemainingVisibility(), Equals, time.Duration(0))
+
+	// the queue's own timeout isn't known
+	resp, err = s.testQueue("TestQueue").ReceiveMessage(nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Messages[0].RemainingVisibility(), Equals, time.Duration(0))
+}
+
+func (s *SQSSuite) TestReceiveMessageLongPollTimedOut(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, receiveNoMessagesXML)
+	resp, err := s.testQueue("TestQueue").ReceiveMessage(&sqs.ReceiveMessageOptions{WaitTimeSeconds: 20})
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("WaitTimeSeconds"), Equals, "20")
+	c.Assert(resp.Empty(), Equals, true)
+	c.Assert(resp.LongPollTimedOut(), Equals, true)
+	c.Assert(resp.Elapsed > 0, Equals, true)
+
+	// a short poll that found nothing didn't time out
+	resp, err = s.testQueue("TestQueue").ReceiveMessage(nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Empty(), Equals, true)
+	c.Assert(resp.LongPollTimedOut(), Equals, false)
+}
+
+// Build a ReceiveMessage response with a message for each of ids.
+func receiveMessagesXML(ids ...string) string {
+	xml := "<ReceiveMessageResponse><ReceiveMessageResult>"
+	for _, id := range ids {
+		xml += fmt.Sprintf("<Message><MessageId>%v</MessageId><ReceiptHandle>rh-%v</ReceiptHandle><Body>body</Body></Message>", id, id)
+	}
+	return xml + "</ReceiveMessageResult></ReceiveMessageResponse>"
+}
+
+func (s *SQSSuite) TestReceiveUpTo(c *C) {
+	var asked []string
+	next := 0
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		asked = append(asked, r.Form.Get("MaxNumberOfMessages"))
+		max, _ := strconv.Atoi(r.Form.Get("MaxNumberOfMessages"))
+		var ids []string
+		for i := 0; i < max; i++ {
+			ids = append(ids, strconv.Itoa(next))
+			next++
+		}
+		respondWith(http.StatusOK, receiveMessagesXML(ids...))(w, r)
+	}
+	messages, err := s.testQueue("TestQueue").ReceiveUpTo(25, 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(messages, HasLen, 25)
+	c.Assert(asked, DeepEquals, []string{"10", "10", "5"})
+	c.Assert(messages[24].MessageId, Equals, "24")
+}
+
+func (s *SQSSuite) TestReceiveUpToStops(c *C) {
+	responses := []string{receiveMessagesXML("a", "b"), receiveMessagesXML("b", "c"), receiveMessagesXML()}
+	calls := 0
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		respondWith(http.StatusOK, responses[calls])(w, r)
+		calls++
+	}
+	messages, err := s.testQueue("TestQueue").ReceiveUpTo(100, 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 3)
+	c.Assert(messages, HasLen, 3)
+	c.Assert(messages[2].MessageId, Equals, "c")
+
+	// a call that only returns messages already received stops too
+	responses = []string{receiveMessagesXML("a"), receiveMessagesXML("a")}
+	calls = 0
+	messages, err = s.testQueue("TestQueue").ReceiveUpTo(100, 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 2)
+	c.Assert(messages, HasLen, 1)
+}
+
+func (s *SQSSuite) TestReceiveUpToWaitBudget(c *C) {
+	var waits []string
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		waits = append(waits, r.Form.Get("WaitTimeSeconds"))
+		time.Sleep(600 * time.Millisecond)
+		respondWith(http.StatusOK, receiveMessagesXML(strconv.Itoa(len(waits))))(w, r)
+	}
+	messages, err := s.testQueue("TestQueue").ReceiveUpTo(100, 1, 30)
+	c.Assert(err, IsNil)
+	c.Assert(messages, HasLen, 1)
+	c.Assert(waits, DeepEquals, []string{"1"})
+}
+
+func (s *SQSSuite) TestReceiveUpToError(c *C) {
+	calls := 0
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls > 1 {
+			respondWith(http.StatusBadRequest, "no")(w, r)
+			return
+		}
+		respondWith(http.StatusOK, receiveMessagesXML("a"))(w, r)
+	}
+	messages, err := s.testQueue("TestQueue").ReceiveUpTo(5, 0, 0)
+	c.Assert(err, NotNil)
+	c.Assert(messages, HasLen, 1)
+
+	_, err = s.testQueue("TestQueue").ReceiveUpTo(0, 0, 0)
+	c.Assert(err, ErrorMatches, ".*n must be at least 1.*")
+}
+
+func (s *SQSSuite) TestMessages(c *C) {
+	var mu sync.Mutex
+	var extended, deleted []string
+	received := false
+	// a server of its own, as requests can still be arriving as the test ends; Close waits for them
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Form.Get("Action") {
+		case "ReceiveMessage":
+			if !received {
+				received = true
+				respondWith(http.StatusOK, receiveMessagesXML("m1"))(w, r)
+				return
+			}
+			mu.Unlock()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(100 * time.Millisecond):
+			}
+			mu.Lock()
+			respondWith(http.StatusOK, receiveNoMessagesXML)(w, r)
+		case "ChangeMessageVisibilityBatch":
+			extended = append(extended, r.Form.Get("ChangeMessageVisibilityBatchRequestEntry.1.ReceiptHandle")+
+				"/"+r.Form.Get("ChangeMessageVisibilityBatchRequestEntry.1.VisibilityTimeout"))
+			respondWith(http.StatusOK, "<ChangeMessageVisibilityBatchResponse><ChangeMessageVisibilityBatchResult/>"+
+				"</ChangeMessageVisibilityBatchResponse>")(w, r)
+		case "DeleteMessage":
+			deleted = append(deleted, r.Form.Get("ReceiptHandle"))
+			respondWith(http.StatusOK, "<DeleteMessageResponse/>")(w, r)
+		}
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := &sqs.MessagesOptions{ReceiveMessageOptions: sqs.ReceiveMessageOptions{VisibilityTimeout: 1},
+		OnError: func(err error) { c.Error(err) }}
+	queue := &sqs.Queue{SQS: s.testSQS(), Name: "TestQueue", Url: server.URL + "/123456789012/TestQueue"}
+	messages, ack := queue.Messages(ctx, opts)
+	msg := <-messages
+	c.Assert(msg.MessageId, Equals, "m1")
+
+	// extended every half visibility timeout until acknowledged
+	time.Sleep(1200 * time.Millisecond)
+	c.Assert(ack(msg), IsNil)
+	mu.Lock()
+	c.Assert(len(extended) >= 2, Equals, true)
+	c.Assert(extended[0], Equals, "rh-m1/1")
+	c.Assert(deleted, DeepEquals, []string{"rh-m1"})
+	extensions := len(extended)
+	mu.Unlock()
+	time.Sleep(600 * time.Millisecond)
+	mu.Lock()
+	c.Assert(len(extended), Equals, extensions)
+	mu.Unlock()
+
+	// the channel is closed once ctx is done
+	cancel()
+	select {
+	case _, ok := <-messages:
+		c.Assert(ok, Equals, false)
+	case <-time.After(time.Second):
+		c.Fatal("messages channel not closed")
+	}
+}
+
+func (s *SQSSuite) TestMessagesDedup(c *C) {
+	var mu sync.Mutex
+	var deleted []string
+	receives := 0
+	acked := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Form.Get("Action") {
+		case "ReceiveMessage":
+			receives++
+			// once it's acknowledged, m1 is delivered again, with a new receipt handle, along with m2
+			switch receives {
+			case 1:
+				respondWith(http.StatusOK, receiveMessagesXML("m1"))(w, r)
+			case 2:
+				mu.Unlock()
+				<-acked
+				mu.Lock()
+				respondWith(http.StatusOK, strings.Replace(receiveMessagesXML("m1", "m2"), "rh-m1", "rh-m1-again", 1))(w, r)
+			default:
+				respondWith(http.StatusOK, receiveNoMessagesXML)(w, r)
+			}
+		case "DeleteMessage":
+			deleted = append(deleted, r.Form.Get("ReceiptHandle"))
+			respondWith(http.StatusOK, "<DeleteMessageResponse/>")(w, r)
+		}
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := &sqs.MessagesOptions{Dedup: sqs.NewMemoryDedupCache(time.Minute, 100),
+		OnError: func(err error) { c.Error(err) }}
+	queue := &sqs.Queue{SQS: s.testSQS(), Name: "TestQueue", Url: server.URL + "/123456789012/TestQueue"}
+	messages, ack := queue.Messages(ctx, opts)
+
+	msg := <-messages
+	c.Assert(msg.MessageId, Equals, "m1")
+	c.Assert(ack(msg), IsNil)
+	close(acked)
+	msg = <-messages
+	c.Assert(msg.MessageId, Equals, "m2")
+	mu.Lock()
+	c.Assert(deleted, DeepEquals, []string{"rh-m1", "rh-m1-again"})
+	mu.Unlock()
+}
+
+func (s *SQSSuite) TestMemoryDedupCache(c *C) {
+	now := time.Date(2014, time.March, 1, 12, 0, 0, 0, time.UTC)
+	cache := sqs.NewMemoryDedupCache(time.Minute, 2)
+	cache.Now = func() time.Time { return now }
+	contains := func(id string) bool {
+		ok, err := cache.Contains(id)
+		c.Assert(err, IsNil)
+		return ok
+	}
+
+	c.Assert(contains("a"), Equals, false)
+	c.Assert(cache.Add("a"), IsNil)
+	now = now.Add(30 * time.Second)
+	c.Assert(cache.Add("b"), IsNil)
+	c.Assert(contains("a"), Equals, true)
+
+	// expired by time
+	now = now.Add(30 * time.Second)
+	c.Assert(contains("a"), Equals, false)
+	c.Assert(contains("b"), Equals, true)
+
+	// and by size: the oldest is dropped
+	c.Assert(cache.Add("c"), IsNil)
+	c.Assert(cache.Add("d"), IsNil)
+	c.Assert(contains("b"), Equals, false)
+	c.Assert(contains("c"), Equals, true)
+	c.Assert(contains("d"), Equals, true)
+
+	// adding again renews
+	now = now.Add(59 * time.Second)
+	c.Assert(cache.Add("c"), IsNil)
+	now = now.Add(time.Second)
+	c.Assert(contains("c"), Equals, true)
+	c.Assert(contains("d"), Equals, false)
+}
+
+func (s *SQSSuite) TestSendBinaryMessage(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, sendMessageXML)
+	data := []byte{0x1f, 0x8b, 0x00, 0xff, '<', '&'}
+	opts := &sqs.SendMessageOptions{MessageAttributes: map[string]sqs.MessageAttributeValue{
+		"Origin": {DataType: "String", StringValue: "test"}}}
+	_, err := s.testQueue("TestQueue").SendBinaryMessage(data, opts)
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("MessageBody"), Equals, "H4sA/zwm")
+	c.Assert(params.Get("MessageAttribute.1.Name"), Equals, "Content-Transfer-Encoding")
+	c.Assert(params.Get("MessageAttribute.1.Value.DataType"), Equals, "String")
+	c.Assert(params.Get("MessageAttribute.1.Value.StringValue"), Equals, "base64")
+	c.Assert(params.Get("MessageAttribute.2.Name"), Equals, "Origin")
+	c.Assert(params.Get("MessageAttribute.2.Value.StringValue"), Equals, "test")
+	c.Assert(opts.MessageAttributes, HasLen, 1) // the caller's options are left alone
+}
+
+const receiveBinaryMessageXML = `<ReceiveMessageResponse>
+  <ReceiveMessageResult>
+    <Message>
+      <MessageId>5fea7756-0ea4-451a-a703-a558b933e274</MessageId>
+      <ReceiptHandle>MbZj6wDWli+JvwwJaBV+3dcjk2YW2vA3+STFFljTM8tJJg6HRG6PYSasuWXPJB+CwLj1FjgXUv1uSj1gUPAWV66FU/WeR4mq2OKpEGYWbnLmpRCJVAyeMjeU5ZBdtcQ+QEauMZc8ZRv37sIW2iJKq3M9MFx1YvV11A2x/KSbkJ0=</ReceiptHandle>
+      <MD5OfBody>381651e2a5045b4f7497243c9de5ffe6</MD5OfBody>
+      <Body>H4sA/zwm</Body>
+      <MD5OfMessageAttributes>61a73cb6db4effd06da28638f32c09f4</MD5OfMessageAttributes>
+      <MessageAttribute>
+        <Name>Content-Transfer-Encoding</Name>
+        <Value>
+          <StringValue>base64</StringValue>
+          <DataType>String</DataType>
+        </Value>
+      </MessageAttribute>
+      <MessageAttribute>
+        <Name>Checksum</Name>
+        <Value>
+          <BinaryValue>AAEC</BinaryValue>
+          <DataType>Binary</DataType>
+        </Value>
+      </MessageAttribute>
+    </Message>
+  </ReceiveMessageResult>
+  <ResponseMetadata>
+    <RequestId>b6633655-283d-45b4-aee4-4e84e0ae6afa</RequestId>
+  </ResponseMetadata>
+</ReceiveMessageResponse>`
+
+func (s *SQSSuite) TestReceiveBinaryMessage(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, receiveBinaryMessageXML)
+	resp, err := s.testQueue("TestQueue").ReceiveMessage(&sqs.ReceiveMessageOptions{MessageAttributeNames: []string{"All"}})
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("MessageAttributeName.1"), Equals, "All")
+	msg := resp.Messages[0]
+	body, err := msg.BinaryBody()
+	c.Assert(err, IsNil)
+	c.Assert(body, DeepEquals, []byte{0x1f, 0x8b, 0x00, 0xff, '<', '&'})
+
+	checksum, ok := msg.MessageAttribute("Checksum")
+	c.Assert(ok, Equals, true)
+	c.Assert(checksum.DataType, Equals, "Binary")
+	c.Assert(checksum.BinaryValue, DeepEquals, []byte{0, 1, 2})
+	c.Assert(msg.MD5OfMessageAttributes, Equals, "61a73cb6db4effd06da28638f32c09f4")
+
+	// a message without the encoding attribute is returned as is
+	plain := sqs.Message{Body: "H4sA/zwm"}
+	body, err = plain.BinaryBody()
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "H4sA/zwm")
+}
+
+func (s *SQSSuite) TestMessageAttributesMD5(c *C) {
+	// each of the name, data type and value is preceded by its length, and the value by its transport type
+	var encoded bytes.Buffer
+	for _, part := range []string{
+		"\x00\x00\x00\x08Checksum", "\x00\x00\x00\x06Binary", "\x02", "\x00\x00\x00\x03\x00\x01\x02",
+		"\x00\x00\x00\x19Content-Transfer-Encoding", "\x00\x00\x00\x06String", "\x01", "\x00\x00\x00\x06base64",
+	} {
+		encoded.WriteString(part)
+	}
+	expected := fmt.Sprintf("%x", md5.Sum(encoded.Bytes()))
+	c.Assert(expected, Equals, "61a73cb6db4effd06da28638f32c09f4")
+
+	attrs := map[string]sqs.MessageAttributeValue{
+		"Content-Transfer-Encoding": {DataType: "String", StringValue: "base64"},
+		"Checksum":                  {DataType: "Binary", BinaryValue: []byte{0, 1, 2}},
+	}
+	c.Assert(sqs.MessageAttributesMD5(attrs), Equals, expected)
+
+	// a custom type takes the transport type of its base type
+	c.Assert(sqs.MessageAttributesMD5(map[string]sqs.MessageAttributeValue{"n": {DataType: "Number.int", StringValue: "1"}}),
+		Equals, fmt.Sprintf("%x", md5.Sum([]byte("\x00\x00\x00\x01n\x00\x00\x00\x0aNumber.int\x01\x00\x00\x00\x011"))))
+}
+
+func (s *SQSSuite) TestReceiveMessageAttributesMD5Mismatch(c *C) {
+	s.handler = respondWith(http.StatusOK, strings.Replace(receiveBinaryMessageXML,
+		"<StringValue>base64</StringValue>", "<StringValue>base65</StringValue>", 1))
+	_, err := s.testQueue("TestQueue").ReceiveMessage(&sqs.ReceiveMessageOptions{MessageAttributeNames: []string{"All"}})
+	c.Assert(errors.Is(err, sqs.ErrAttributesMD5Mismatch), Equals, true)
+	c.Assert(err, ErrorMatches, ".*message 5fea7756-0ea4-451a-a703-a558b933e274: got 61a73cb6db4effd06da28638f32c09f4, computed .*")
+}
+
+const getQueueAttributesXML = `<GetQueueAttributesResponse>
+  <GetQueueAttributesResult>
+    <Attribute>
+      <Name>ReceiveMessageWaitTimeSeconds</Name>
+      <Value>2</Value>
+    </Attribute>
+    <Attribute>
+      <Name>VisibilityTimeout</Name>
+      <Value>30</Value>
+    </Attribute>
+    <Attribute>
+      <Name>ApproximateNumberOfMessages</Name>
+      <Value>12</Value>
+    </Attribute>
+    <Attribute>
+      <Name>CreatedTimestamp</Name>
+      <Value>1347037567</Value>
+    </Attribute>
+    <Attribute>
+      <Name>LastModifiedTimestamp</Name>
+      <Value>1364248361</Value>
+    </Attribute>
+    <Attribute>
+      <Name>QueueArn</Name>
+      <Value>arn:aws:sqs:us-east-1:123456789012:TestQueue</Value>
+    </Attribute>
+    <Attribute>
+      <Name>MaximumMessageSize</Name>
+      <Value>262144</Value>
+    </Attribute>
+  </GetQueueAttributesResult>
+  <ResponseMetadata>
+    <RequestId>1ea71be5-b5a2-4f9d-b85a-945d8d08cd0b</RequestId>
+  </ResponseMetadata>
+</GetQueueAttributesResponse>`
+
+func (s *SQSSuite) TestGetQueueAttributes(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, getQueueAttributesXML)
+	resp, err := s.testQueue("TestQueue").GetQueueAttributes("All")
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("Action"), Equals, "GetQueueAttributes")
+	c.Assert(params.Get("AttributeName.1"), Equals, "All")
+	c.Assert(resp.AttributeMap()["VisibilityTimeout"], Equals, "30")
+
+	attrs, err := resp.QueueAttributes()
+	c.Assert(err, IsNil)
+	c.Assert(attrs.VisibilityTimeout, Equals, 30)
+	c.Assert(attrs.ApproximateNumberOfMessages, Equals, 12)
+	c.Assert(attrs.MaximumMessageSize, Equals, 262144)
+	c.Assert(attrs.QueueArn, Equals, "arn:aws:sqs:us-east-1:123456789012:TestQueue")
+	c.Assert(attrs.CreatedTimestamp.Equal(time.Date(2012, time.September, 7, 17, 6, 7, 0, time.UTC)), Equals, true)
+	c.Assert(attrs.LastModifiedTimestamp.Equal(time.Date(2013, time.March, 25, 21, 52, 41, 0, time.UTC)), Equals, true)
+	c.Assert(attrs.LastModifiedTimestamp.Location(), Equals, time.UTC)
+}
+
+const nonExistentQueueXML = `<ErrorResponse xmlns="http://queue.amazonaws.com/doc/2012-11-05/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>AWS.SimpleQueueService.NonExistentQueue</Code>
+    <Message>The specified queue does not exist for this wsdl version.</Message>
+    <Detail/>
+  </Error>
+  <RequestId>05d1a9c1-8a4c-5c38-93a1-3fa0b1f4d9a4</RequestId>
+</ErrorResponse>`
+
+func (s *SQSSuite) TestCheckAccess(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, getQueueAttributesXML)
+	c.Assert(s.testQueue("TestQueue").CheckAccess(), IsNil)
+	c.Assert(params.Get("Action"), Equals, "GetQueueAttributes")
+	c.Assert(params.Get("AttributeName.1"), Equals, "QueueArn")
+	c.Assert(params.Get("AttributeName.2"), Equals, "")
+}
+
+func (s *SQSSuite) TestCheckAccessErrors(c *C) {
+	for body, sentinel := range map[string]error{nonExistentQueueXML: sqs.ErrQueueDoesNotExist, accessDeniedXML: sqs.ErrAccessDenied} {
+		status := http.StatusBadRequest
+		if sentinel == sqs.ErrAccessDenied {
+			status = http.StatusForbidden
+		}
+		s.handler = respondWith(status, body)
+		err := s.testQueue("TestQueue").CheckAccess()
+		c.Assert(errors.Is(err, sentinel), Equals, true)
+		var errResponse *sqs.ErrorResponse
+		c.Assert(errors.As(err, &errResponse), Equals, true)
+		c.Assert(err, ErrorMatches, "sqs: .*/123456789012/TestQueue: sqs.ErrorResponse .*")
+	}
+
+	s.handler = respondWith(http.StatusBadRequest, strings.Replace(nonExistentQueueXML,
+		"AWS.SimpleQueueService.NonExistentQueue", "InvalidParameterValue", 1))
+	err := s.testQueue("TestQueue").CheckAccess()
+	_, ok := err.(*sqs.ErrorResponse)
+	c.Assert(ok, Equals, true)
+	c.Assert(errors.Is(err, sqs.ErrQueueDoesNotExist), Equals, false)
+}
+
+// Respond to CreateQueue with the URL of the queue on the test server, and record the parameters of
+// each; fail the creation of the queues named in fail.
+func (s *SQSSuite) recordCreateQueue(created *[]url.Values, fail ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*created = append(*created, r.Form)
+		name := r.Form.Get("QueueName")
+		for _, f := range fail {
+			if name == f {
+				respondWith(http.StatusBadRequest, strings.Replace(nonExistentQueueXML,
+					"AWS.SimpleQueueService.NonExistentQueue", "InvalidAttributeValue", 1))(w, r)
+				return
+			}
+		}
+		respondWith(http.StatusOK, fmt.Sprintf(`<CreateQueueResponse><CreateQueueResult>
+<QueueUrl>%v/123456789012/%v</QueueUrl></CreateQueueResult></CreateQueueResponse>`, s.server.URL, name))(w, r)
+	}
+}
+
+func (s *SQSSuite) TestCreateQueueWithAttributes(c *C) {
+	var created []url.Values
+	s.handler = s.recordCreateQueue(&created)
+	queue, _, err := s.testSQS().CreateQueueWithAttributes("TestQueue", map[string]string{"VisibilityTimeout": "60", "DelaySeconds": "5"})
+	c.Assert(err, IsNil)
+	c.Assert(queue.Url, Equals, s.server.URL+"/123456789012/TestQueue")
+	c.Assert(created[0].Get("Attribute.1.Name"), Equals, "DelaySeconds")
+	c.Assert(created[0].Get("Attribute.1.Value"), Equals, "5")
+	c.Assert(created[0].Get("Attribute.2.Name"), Equals, "VisibilityTimeout")
+
+	_, _, err = s.testSQS().CreateQueueWithAttributes("TestQueue", map[string]string{"Visibility": "60"})
+	c.Assert(err, ErrorMatches, `sqs: unknown queue attribute name "Visibility".*`)
+	c.Assert(len(created), Equals, 1)
+}
+
+func (s *SQSSuite) TestCreateQueueWithDLQ(c *C) {
+	var created []url.Values
+	s.handler = s.recordCreateQueue(&created)
+	main, dlq, err := s.testSQS().CreateQueueWithDLQ("TestQueue", 5, map[string]string{"VisibilityTimeout": "60"})
+	c.Assert(err, IsNil)
+	c.Assert(main.Name, Equals, "TestQueue")
+	c.Assert(dlq.Name, Equals, "TestQueue-dlq")
+	c.Assert(len(created), Equals, 2)
+	c.Assert(created[0].Get("QueueName"), Equals, "TestQueue-dlq")
+	c.Assert(created[0].Get("Attribute.1.Name"), Equals, "")
+	c.Assert(created[1].Get("QueueName"), Equals, "TestQueue")
+	c.Assert(created[1].Get("Attribute.1.Name"), Equals, "RedrivePolicy")
+	c.Assert(created[1].Get("Attribute.1.Value"), Equals,
+		`{"deadLetterTargetArn":"arn:aws:sqs:test-region:123456789012:TestQueue-dlq","maxReceiveCount":"5"}`)
+	c.Assert(created[1].Get("Attribute.2.Name"), Equals, "VisibilityTimeout")
+	c.Assert(created[1].Get("Attribute.2.Value"), Equals, "60")
+}
+
+func (s *SQSSuite) TestCreateQueueWithDLQFifo(c *C) {
+	var created []url.Values
+	s.handler = s.recordCreateQueue(&created)
+	_, dlq, err := s.testSQS().CreateQueueWithDLQ("TestQueue.fifo", 3, map[string]string{"FifoQueue": "true"})
+	c.Assert(err, IsNil)
+	c.Assert(dlq.Name, Equals, "TestQueue-dlq.fifo")
+	c.Assert(created[0].Get("Attribute.1.Name"), Equals, "FifoQueue")
+	c.Assert(created[0].Get("Attribute.1.Value"), Equals, "true")
+}
+
+func (s *SQSSuite) TestCreateQueueWithDLQFailure(c *C) {
+	var created []url.Values
+	s.handler = s.recordCreateQueue(&created, "TestQueue")
+	main, dlq, err := s.testSQS().CreateQueueWithDLQ("TestQueue", 5, nil)
+	_, ok := err.(*sqs.ErrorResponse)
+	c.Assert(ok, Equals, true)
+	c.Assert(main, IsNil)
+	c.Assert(dlq.Name, Equals, "TestQueue-dlq")
+
+	created = nil
+	s.handler = s.recordCreateQueue(&created, "TestQueue-dlq")
+	main, dlq, err = s.testSQS().CreateQueueWithDLQ("TestQueue", 5, nil)
+	c.Assert(err, NotNil)
+	c.Assert(main, IsNil)
+	c.Assert(dlq, IsNil)
+	c.Assert(len(created), Equals, 1)
+
+	_, _, err = s.testSQS().CreateQueueWithDLQ("TestQueue", 0, nil)
+	c.Assert(err, ErrorMatches, "sqs.CreateQueueWithDLQ: maxReceiveCount must be between 1 and 1000, got 0")
+}
+
+func (s *SQSSuite) TestSetQueueAttributes(c *C) {
+	var params url.Values
+	var method string
+	record := recordParams(&params, http.StatusOK, "<SetQueueAttributesResponse/>")
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		record(w, r)
+	}
+	_, err := s.testQueue("TestQueue").SetQueueAttributes(map[string]string{"VisibilityTimeout": "60", "DelaySeconds": "5"})
+	c.Assert(err, IsNil)
+	c.Assert(method, Equals, "POST")
+	c.Assert(params.Get("Action"), Equals, "SetQueueAttributes")
+	c.Assert(params.Get("Attribute.1.Name"), Equals, "DelaySeconds")
+	c.Assert(params.Get("Attribute.1.Value"), Equals, "5")
+	c.Assert(params.Get("Attribute.2.Name"), Equals, "VisibilityTimeout")
+	c.Assert(params.Get("Attribute.2.Value"), Equals, "60")
+
+	_, err = s.testQueue("TestQueue").SetQueueAttributes(map[string]string{"visibilityTimeout": "60"})
+	c.Assert(err, ErrorMatches, `sqs: unknown queue attribute name "visibilityTimeout".*`)
+}
+
+func (s *SQSSuite) TestSetPolicy(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, "<SetQueueAttributesResponse/>")
+	err := s.testQueue("TestQueue").SetPolicy([]sqs.PolicyStatement{
+		sqs.AllowSNSTopic("arn:aws:sns:us-east-1:123456789012:TestTopic"),
+		{Sid: "Everyone", Effect: "Deny", Action: []string{"sqs:DeleteQueue", "sqs:PurgeQueue"}},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("Attribute.1.Name"), Equals, "Policy")
+	c.Assert(params.Get("Attribute.1.Value"), Equals, `{"Version":"2012-10-17","Statement":[`+
+		`{"Effect":"Allow","Principal":{"Service":["sns.amazonaws.com"]},"Action":["sqs:SendMessage"],`+
+		`"Resource":"arn:aws:sqs:test-region:123456789012:TestQueue",`+
+		`"Condition":{"ArnEquals":{"aws:SourceArn":["arn:aws:sns:us-east-1:123456789012:TestTopic"]}}},`+
+		`{"Sid":"Everyone","Effect":"Deny","Principal":"*","Action":["sqs:DeleteQueue","sqs:PurgeQueue"],`+
+		`"Resource":"arn:aws:sqs:test-region:123456789012:TestQueue"}]}`)
+}
+
+const getPolicyXML = `<GetQueueAttributesResponse>
+  <GetQueueAttributesResult>
+    <Attribute>
+      <Name>Policy</Name>
+      <Value>{&quot;Version&quot;:&quot;2012-10-17&quot;,&quot;Id&quot;:&quot;TestPolicy&quot;,&quot;Statement&quot;:[{&quot;Sid&quot;:&quot;Topic&quot;,&quot;Effect&quot;:&quot;Allow&quot;,&quot;Principal&quot;:{&quot;AWS&quot;:&quot;*&quot;},&quot;Action&quot;:&quot;SQS:SendMessage&quot;,&quot;Resource&quot;:&quot;arn:aws:sqs:us-east-1:123456789012:TestQueue&quot;,&quot;Condition&quot;:{&quot;ArnLike&quot;:{&quot;aws:SourceArn&quot;:&quot;arn:aws:sns:us-east-1:123456789012:TestTopic&quot;}}},{&quot;Effect&quot;:&quot;Deny&quot;,&quot;Principal&quot;:&quot;*&quot;,&quot;Action&quot;:[&quot;SQS:DeleteQueue&quot;],&quot;Resource&quot;:&quot;arn:aws:sqs:us-east-1:123456789012:TestQueue&quot;}]}</Value>
+    </Attribute>
+  </GetQueueAttributesResult>
+  <ResponseMetadata>
+    <RequestId>8e3c1b5e-6f8b-4d0c-a0a5-3f8e5d7f6b2a</RequestId>
+  </ResponseMetadata>
+</GetQueueAttributesResponse>`
+
+func (s *SQSSuite) TestGetPolicy(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, getPolicyXML)
+	policy, err := s.testQueue("TestQueue").GetPolicy()
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("AttributeName.1"), Equals, "Policy")
+	c.Assert(policy, DeepEquals, &sqs.Policy{Version: "2012-10-17", Id: "TestPolicy", Statement: []sqs.PolicyStatement{
+		{Sid: "Topic", Effect: "Allow", Principal: map[string][]string{"AWS": {"*"}}, Action: []string{"SQS:SendMessage"},
+			Resource:  "arn:aws:sqs:us-east-1:123456789012:TestQueue",
+			Condition: map[string]map[string][]string{"ArnLike": {"aws:SourceArn": {"arn:aws:sns:us-east-1:123456789012:TestTopic"}}}},
+		{Effect: "Deny", Action: []string{"SQS:DeleteQueue"}, Resource: "arn:aws:sqs:us-east-1:123456789012:TestQueue"},
+	}})
+
+	s.handler = respondWith(http.StatusOK, getQueueAttributesXML)
+	policy, err = s.testQueue("TestQueue").GetPolicy()
+	c.Assert(err, IsNil)
+	c.Assert(policy, IsNil)
+}
+
+func (s *SQSSuite) TestGetQueueAttributesNames(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, getQueueAttributesXML)
+	queue := s.testQueue("TestQueue")
+
+	_, err := queue.GetQueueAttributes("VisibilityTimeout")
+	c.Assert(err, IsNil)
+	_, err = queue.GetQueueAttributes("All")
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("AttributeName.1"), Equals, "All")
+
+	params = nil
+	_, err = queue.GetQueueAttributes("QueueArn", "VisibilityTimeOut")
+	c.Assert(err, ErrorMatches, `sqs: unknown queue attribute name "VisibilityTimeOut" \(did you mean "VisibilityTimeout"\?\)`)
+	_, err = queue.GetQueueAttributes("NoSuchAttribute")
+	c.Assert(err, ErrorMatches, `sqs: unknown queue attribute name "NoSuchAttribute"`)
+	c.Assert(params, IsNil) // no request made
+
+	queue.AllowUnknownAttributes = true
+	_, err = queue.GetQueueAttributes("NoSuchAttribute")
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("AttributeName.1"), Equals, "NoSuchAttribute")
+}
+
+const retentionAttributesXML = `<GetQueueAttributesResponse>
+  <GetQueueAttributesResult>
+    <Attribute>
+      <Name>DelaySeconds</Name>
+      <Value>600</Value>
+    </Attribute>
+    <Attribute>
+      <Name>MessageRetentionPeriod</Name>
+      <Value>3600</Value>
+    </Attribute>
+  </GetQueueAttributesResult>
+</GetQueueAttributesResponse>`
+
+func (s *SQSSuite) TestSendMessageHandlingTime(c *C) {
+	var actions []string
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		action := r.Form.Get("Action")
+		actions = append(actions, action)
+		if action == "GetQueueAttributes" {
+			respondWith(http.StatusOK, retentionAttributesXML)(w, r)
+			return
+		}
+		respondWith(http.StatusOK, sendMessageXML)(w, r)
+	}
+	queue := s.testQueue("TestQueue")
+
+	// the queue's delay (10 minutes) plus 55 minutes handling is over the hour's retention
+	_, err := queue.SendMessage("hello", &sqs.SendMessageOptions{HandlingTime: 55 * time.Minute})
+	c.Assert(errors.Is(err, sqs.ErrExceedsRetention), Equals, true)
+	c.Assert(actions, DeepEquals, []string{"GetQueueAttributes"})
+
+	_, err = queue.SendMessage("hello", &sqs.SendMessageOptions{DelaySeconds: 60, HandlingTime: 55 * time.Minute})
+	c.Assert(err, IsNil)
+	c.Assert(actions, DeepEquals, []string{"GetQueueAttributes", "GetQueueAttributes", "SendMessage"})
+
+	// opt-in: no attributes fetch without a HandlingTime
+	actions = nil
+	_, err = queue.SendMessage("hello", &sqs.SendMessageOptions{DelaySeconds: 900})
+	c.Assert(err, IsNil)
+	c.Assert(actions, DeepEquals, []string{"SendMessage"})
+}
+
+func (s *SQSSuite) TestValidateQueueName(c *C) {
+	c.Assert(sqs.ValidateQueueName("Test_queue-1"), IsNil)
+	c.Assert(sqs.ValidateQueueName("Test_queue-1.fifo"), IsNil)
+	c.Assert(sqs.ValidateQueueName(strings.Repeat("a", 80)), IsNil)
+	c.Assert(sqs.ValidateQueueName(strings.Repeat("a", 75)+".fifo"), IsNil)
+
+	c.Assert(sqs.ValidateQueueName(strings.Repeat("a", 81)), ErrorMatches, "sqs: queue name exceeds 80 characters .*")
+	c.Assert(sqs.ValidateQueueName(""), ErrorMatches, "sqs: queue name is empty")
+	c.Assert(sqs.ValidateQueueName("83*A111"), ErrorMatches, "sqs: queue name 83\\*A111 has invalid character '\\*'.*")
+
+	// valid as a standard queue name, but too long once the suffix is added
+	name := strings.Repeat("a", 78)
+	c.Assert(sqs.ValidateQueueName(name), IsNil)
+	c.Assert(sqs.ValidateQueueName(name+".fifo"), ErrorMatches, "sqs: FIFO queue base name exceeds 75 characters .*has 78.*")
+	c.Assert(sqs.ValidateQueueName(".fifo"), ErrorMatches, "sqs: FIFO queue base name is empty")
+	c.Assert(sqs.ValidateQueueName("my.queue.fifo"), ErrorMatches, "sqs: FIFO queue base name my.queue has invalid character '\\.'.*")
+}
+
+func (s *SQSSuite) TestQueueString(c *C) {
+	queue := &sqs.Queue{SQS: sqs.NewSQSWithTransport(&sqs.USEast, testCredentials, &cannedTransport{}),
+		Name: "TestQueue", Url: "https://sqs.us-east-1.amazonaws.com/123456789012/TestQueue"}
+	expect := "Queue{Name: TestQueue, Url: https://sqs.us-east-1.amazonaws.com/123456789012/TestQueue}"
+	c.Assert(queue.String(), Equals, expect)
+	c.Assert(fmt.Sprintf("%+v", queue), Equals, expect)
+	c.Assert(fmt.Sprintf("%+v", queue), Not(Matches), ".*ITSASECRET.*")
+	c.Assert(fmt.Sprintf("%+v", queue.SQS), Not(Matches), ".*ITSASECRET.*")
+}
+
+func (s *SQSSuite) TestQueueEqual(c *C) {
+	queue := s.testQueue("TestQueue")
+	c.Assert(queue.Equal(s.testQueue("TestQueue")), Equals, true)
+	c.Assert(queue.Equal(s.testQueue("OtherQueue")), Equals, false)
+	c.Assert(queue.Equal(nil), Equals, false)
+}
+
+func (s *SQSSuite) TestQueueARN(c *C) {
+	c.Assert(sqs.QueueARN("us-east-1", "123456789012", "MyQueue"), Equals, "arn:aws:sqs:us-east-1:123456789012:MyQueue")
+	c.Assert(sqs.QueueARN("us-gov-west-1", "123456789012", "MyQueue"), Equals,
+		"arn:aws-us-gov:sqs:us-gov-west-1:123456789012:MyQueue")
+	c.Assert(sqs.QueueARN("cn-north-1", "123456789012", "MyQueue"), Equals, "arn:aws-cn:sqs:cn-north-1:123456789012:MyQueue")
+}
+
+func (s *SQSSuite) TestQueueARNFromUrl(c *C) {
+	queue := &sqs.Queue{SQS: sqs.NewSQSWithTransport(&sqs.CNNorth, testCredentials, &cannedTransport{}),
+		Name: "MyQueue", Url: "https://sqs.cn-north-1.amazonaws.com.cn/123456789012/MyQueue"}
+	arn, err := queue.ARN()
+	c.Assert(err, IsNil)
+	c.Assert(arn, Equals, "arn:aws-cn:sqs:cn-north-1:123456789012:MyQueue")
+}
+
+func (s *SQSSuite) TestQueueARNFetched(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, getQueueAttributesXML)
+	queue := s.testQueue("TestQueue")
+	queue.Url = s.server.URL + "/TestQueue"
+	arn, err := queue.ARN()
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("AttributeName.1"), Equals, "QueueArn")
+	c.Assert(arn, Equals, "arn:aws:sqs:us-east-1:123456789012:TestQueue")
+}
+
+func (s *SQSSuite) TestQueueAttributesBadValue(c *C) {
+	resp := &sqs.GetQueueAttributesResponse{Attributes: []sqs.Attribute{{Name: "CreatedTimestamp", Value: "yesterday"}}}
+	_, err := resp.QueueAttributes()
+	c.Assert(err, Not(IsNil))
+}
+
+const sendMessageBatchXML = `<SendMessageBatchResponse>
+  <SendMessageBatchResult>
+    <SendMessageBatchResultEntry>
+      <Id>test_msg_001</Id>
+      <MessageId>0a5231c7-8bff-4955-be2e-8dc7c50a25fa</MessageId>
+      <MD5OfMessageBody>0e024d309850c78cba5eabbeff7cae71</MD5OfMessageBody>
+    </SendMessageBatchResultEntry>
+    <BatchResultErrorEntry>
+      <Id>test_msg_002</Id>
+      <SenderFault>true</SenderFault>
+      <Code>InvalidParameterValue</Code>
+      <Message>Value for parameter DelaySeconds is invalid.</Message>
+    </BatchResultErrorEntry>
+    <BatchResultErrorEntry>
+      <Id>test_msg_003</Id>
+      <SenderFault>false</SenderFault>
+      <Code>InternalError</Code>
+      <Message>We encountered an internal error.</Message>
+    </BatchResultErrorEntry>
+  </SendMessageBatchResult>
+  <ResponseMetadata>
+    <RequestId>ca1ad5d0-8271-408b-8d0f-1351bf547e74</RequestId>
+  </ResponseMetadata>
+</SendMessageBatchResponse>`
+
+func (s *SQSSuite) TestSendMessageBatchPartialFailure(c *C) {
+	var params url.Values
+	s.handler = recordParams(&params, http.StatusOK, sendMessageBatchXML)
+	resp, err := s.testQueue("TestQueue").SendMessageBatch([]sqs.BatchSendEntry{
+		{Id: "test_msg_001", MessageBody: "test message body 1"},
+		{Id: "test_msg_002", MessageBody: "test message body 2", SendMessageOptions: sqs.SendMessageOptions{DelaySeconds: 901}},
+		{Id: "test_msg_003", MessageBody: "test message body 3"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(params.Get("Action"), Equals, "SendMessageBatch")
+	c.Assert(params.Get("SendMessageBatchRequestEntry.1.Id"), Equals, "test_msg_001")
+	c.Assert(params.Get("SendMessageBatchRequestEntry.2.MessageBody"), Equals, "test message body 2")
+	c.Assert(params.Get("SendMessageBatchRequestEntry.2.DelaySeconds"), Equals, "901")
+	c.Assert(params.Get("SendMessageBatchRequestEntry.1.DelaySeconds"), Equals, "")
+
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Assert(resp.Successful, DeepEquals, []sqs.SendMessageBatchResultEntry{{Id: "test_msg_001",
+		MessageId: "0a5231c7-8bff-4955-be2e-8dc7c50a25fa", MD5OfMessageBody: "0e024d309850c78cba5eabbeff7cae71"}})
+	c.Assert(len(resp.Failed), Equals, 2)
+	c.Assert(resp.Failed[0].Id, Equals, "test_msg_002")
+	c.Assert(resp.Failed[0].SenderFault, Equals, true)
+	c.Assert(resp.Failed[1].Code, Equals, "InternalError")
+	c.Assert(resp.Failed[1].SenderFault, Equals, false)
+}
+
+func (s *SQSSuite) TestSendMessageBatchContentLength(c *C) {
+	var contentLength int64
+	var body []byte
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.ContentLength
+		body, _ = ioutil.ReadAll(r.Body)
+		respondWith(http.StatusOK, sendMessageBatchXML)(w, r)
+	}
+	entries := []sqs.BatchSendEntry{{Id: "msg1", MessageBody: strings.Repeat("a", 10000)}, {Id: "msg2", MessageBody: "b"}}
+	_, err := s.testQueue("TestQueue").SendMessageBatch(entries)
+	c.Assert(err, IsNil)
+	c.Assert(contentLength > 10000, Equals, true)
+	c.Assert(contentLength, Equals, int64(len(body)))
+}
+
+func (s *SQSSuite) TestSendMessageBatchRequestError(c *C) {
+	s.handler = respondWith(http.StatusForbidden, accessDeniedXML)
+	resp, err := s.testQueue("TestQueue").SendMessageBatch([]sqs.BatchSendEntry{{Id: "1", MessageBody: "body"}})
+	c.Assert(resp, IsNil)
+	_, ok := err.(*sqs.ErrorResponse)
+	c.Assert(ok, Equals, true)
+}
+
+// Record the entry Ids of each SendMessageBatch request, and respond with each entry sent successfully.
+func recordBatches(batches *[][]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		var ids []string
+		results := ""
+		for i := 1; r.Form.Get(fmt.Sprintf("SendMessageBatchRequestEntry.%d.Id", i)) != ""; i++ {
+			id := r.Form.Get(fmt.Sprintf("SendMessageBatchRequestEntry.%d.Id", i))
+			ids = append(ids, id)
+			results += fmt.Sprintf("<SendMessageBatchResultEntry><Id>%v</Id><MessageId>m-%v</MessageId></SendMessageBatchResultEntry>", id, id)
+		}
+		*batches = append(*batches, ids)
+		respondWith(http.StatusOK, fmt.Sprintf(`<SendMessageBatchResponse><SendMessageBatchResult>%v</SendMessageBatchResult>
+<ResponseMetadata><RequestId>req-%v</RequestId></ResponseMetadata></SendMessageBatchResponse>`, results, len(*batches)))(w, r)
+	}
+}
+
+func (s *SQSSuite) TestSendMessageBatchAutoSplitsByCount(c *C) {
+	var batches [][]string
+	s.handler = recordBatches(&batches)
+	entries := make([]sqs.BatchSendEntry, 25)
+	for i := range entries {
+		entries[i] = sqs.BatchSendEntry{Id: fmt.Sprintf("msg%02d", i), MessageBody: "body"}
+	}
+	resp, err := s.testQueue("TestQueue").SendMessageBatchAuto(entries)
+	c.Assert(err, IsNil)
+	c.Assert(len(batches), Equals, 3)
+	c.Assert(len(batches[0]), Equals, 10)
+	c.Assert(len(batches[1]), Equals, 10)
+	c.Assert(batches[2], DeepEquals, []string{"msg20", "msg21", "msg22", "msg23", "msg24"})
+	c.Assert(len(resp.Successful), Equals, 25)
+	c.Assert(resp.Successful[24].MessageId, Equals, "m-msg24")
+	c.Assert(resp.RequestId, Equals, "req-3")
+}
+
+func (s *SQSSuite) TestSendMessageBatchAutoSplitsBySize(c *C) {
+	var batches [][]string
+	s.handler = recordBatches(&batches)
+	big := strings.Repeat("a", 100<<10)
+	entries := []sqs.BatchSendEntry{
+		{Id: "1", MessageBody: big},
+		{Id: "2", MessageBody: big},
+		{Id: "3", MessageBody: big, SendMessageOptions: sqs.SendMessageOptions{MessageAttributes: map[string]sqs.MessageAttributeValue{
+			"attr": {DataType: "Binary", BinaryValue: make([]byte, 100<<10)}}}},
+		{Id: "4", MessageBody: "small"},
+	}
+	resp, err := s.testQueue("TestQueue").SendMessageBatchAuto(entries)
+	c.Assert(err, IsNil)
+	c.Assert(batches, DeepEquals, [][]string{{"1", "2"}, {"3", "4"}})
+	c.Assert(len(resp.Successful), Equals, 4)
+}
+
+func (s *SQSSuite) TestSendMessageBatchAutoInvalidEntries(c *C) {
+	var batches [][]string
+	s.handler = recordBatches(&batches)
+	_, err := s.testQueue("TestQueue").SendMessageBatchAuto([]sqs.BatchSendEntry{
+		{Id: "1", MessageBody: "a"}, {Id: "2", MessageBody: strings.Repeat("b", sqs.MAX_BATCH_BYTES+1)}})
+	c.Assert(err, ErrorMatches, "sqs.SendMessageBatchAuto: entry 2 is 262145 bytes, .*")
+	_, err = s.testQueue("TestQueue").SendMessageBatchAuto([]sqs.BatchSendEntry{
+		{Id: "1", MessageBody: "a"}, {Id: "1", MessageBody: "b"}})
+	c.Assert(err, ErrorMatches, `sqs: duplicate batch entry Id "1"`)
+	c.Assert(len(batches), Equals, 0)
+}
+
+func (s *SQSSuite) TestSendMessageBatchAutoRequestError(c *C) {
+	var batches [][]string
+	record := recordBatches(&batches)
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		if len(batches) == 1 {
+			respondWith(http.StatusForbidden, accessDeniedXML)(w, r)
+			return
+		}
+		record(w, r)
+	}
+	entries := make([]sqs.BatchSendEntry, 15)
+	for i := range entries {
+		entries[i] = sqs.BatchSendEntry{Id: strconv.Itoa(i), MessageBody: "body"}
+	}
+	resp, err := s.testQueue("TestQueue").SendMessageBatchAuto(entries)
+	_, ok := err.(*sqs.ErrorResponse)
+	c.Assert(ok, Equals, true)
+	c.Assert(len(resp.Successful), Equals, 10)
+}
+
+const changeMessageVisibilityXML = `<ChangeMessageVisibilityResponse>
+  <ResponseMetadata>
+    <RequestId>6a7a282a-d013-4a59-aba9-335b0fa48bed</RequestId>
+  </ResponseMetadata>
+</ChangeMessageVisibilityResponse>`
+
+func (s *SQSSuite) TestChangeMessageVisibility(c *C) {
+	var query url.Values
+	s.handler = recordParams(&query, http.StatusOK, changeMessageVisibilityXML)
+	resp, err := s.testQueue("TestQueue").ChangeMessageVisibility("MbZj6wDWli+JvwwJaBV+3dcjk2YW2vA3+STFFljT", 60)
+	c.Assert(err, IsNil)
+	c.Assert(resp.RequestId, Equals, "6a7a282a-d013-4a59-aba9-335b0fa48bed")
+	c.Assert(query.Get("Action"), Equals, "ChangeMessageVisibility")
+	c.Assert(query.Get("ReceiptHandle"), Equals, "MbZj6wDWli+JvwwJaBV+3dcjk2YW2vA3+STFFljT")
+	c.Assert(query.Get("VisibilityTimeout"), Equals, "60")
+}
+
+const changeMessageVisibilityBatchXML = `<ChangeMessageVisibilityBatchResponse>
+  <ChangeMessageVisibilityBatchResult>
+    <ChangeMessageVisibilityBatchResultEntry>
+      <Id>change_visibility_msg_2</Id>
+    </ChangeMessageVisibilityBatchResultEntry>
+    <BatchResultErrorEntry>
+      <Id>change_visibility_msg_3</Id>
+      <SenderFault>true</SenderFault>
+      <Code>ReceiptHandleIsInvalid</Code>
+      <Message>The input receipt handle is invalid.</Message>
+    </BatchResultErrorEntry>
+  </ChangeMessageVisibilityBatchResult>
+  <ResponseMetadata>
+    <RequestId>ca9668f7-ab1b-4f7a-8859-f15747ab17a7</RequestId>
+  </ResponseMetadata>
+</ChangeMessageVisibilityBatchResponse>`
+
+func (s *SQSSuite) TestChangeMessageVisibilityBatch(c *C) {
+	var query url.Values
+	s.handler = recordParams(&query, http.StatusOK, changeMessageVisibilityBatchXML)
+	resp, err := s.testQueue("TestQueue").ChangeMessageVisibilityBatch([]sqs.BatchVisibilityEntry{
+		{Id: "change_visibility_msg_2", ReceiptHandle: "handle2", VisibilityTimeout: 45},
+		{Id: "change_visibility_msg_3", ReceiptHandle: "handle3", VisibilityTimeout: 45},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(query.Get("Action"), Equals, "ChangeMessageVisibilityBatch")
+	c.Assert(query.Get("ChangeMessageVisibilityBatchRequestEntry.1.Id"), Equals, "change_visibility_msg_2")
+	c.Assert(query.Get("ChangeMessageVisibilityBatchRequestEntry.2.ReceiptHandle"), Equals, "handle3")
+	c.Assert(query.Get("ChangeMessageVisibilityBatchRequestEntry.2.VisibilityTimeout"), Equals, "45")
+
+	c.Assert(resp.Successful, DeepEquals, []sqs.ChangeMessageVisibilityBatchResultEntry{{Id: "change_visibility_msg_2"}})
+	c.Assert(resp.Failed, DeepEquals, []sqs.BatchResultErrorEntry{{Id: "change_visibility_msg_3",
+		Code: "ReceiptHandleIsInvalid", Message: "The input receipt handle is invalid.", SenderFault: true}})
+}
+
+func (s *SQSSuite) TestChangeMessageVisibilityBatchSize(c *C) {
+	queue := s.testQueue("TestQueue")
+	_, err := queue.ChangeMessageVisibilityBatch(nil)
+	c.Assert(err, Not(IsNil))
+	_, err = queue.ChangeMessageVisibilityBatch(make([]sqs.BatchVisibilityEntry, 11))
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *SQSSuite) TestBatchEntryIds(c *C) {
+	queue := s.testQueue("TestQueue")
+	send := func(ids ...string) error {
+		entries := make([]sqs.BatchSendEntry, len(ids))
+		for i, id := range ids {
+			entries[i] = sqs.BatchSendEntry{Id: id, MessageBody: "body"}
+		}
+		_, err := queue.SendMessageBatch(entries)
+		return err
+	}
+	c.Assert(send("msg-1", "msg-2", "msg-1"), ErrorMatches, `sqs: duplicate batch entry Id "msg-1"`)
+	c.Assert(send("msg-1", strings.Repeat("x", 81)), ErrorMatches, `sqs: invalid batch entry Id "x+", .*`)
+	c.Assert(send("msg.1"), ErrorMatches, `sqs: invalid batch entry Id "msg.1", .*`)
+	c.Assert(send(""), ErrorMatches, `sqs: invalid batch entry Id "", .*`)
+
+	_, err := queue.ChangeMessageVisibilityBatch([]sqs.BatchVisibilityEntry{{Id: "a", ReceiptHandle: "r1"}, {Id: "a", ReceiptHandle: "r2"}})
+	c.Assert(err, ErrorMatches, `sqs: duplicate batch entry Id "a"`)
+}
+
+// An http.RoundTripper serving canned responses.
+type cannedTransport struct {
+	status   int
+	header   http.Header
+	body     string
+	requests []*http.Request
+}
+
+func (t *cannedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req)
+	header := t.header
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", t.status, http.StatusText(t.status)),
+		StatusCode: t.status,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(t.body)),
+		Request:    req,
+	}, nil
+}
+
+func (s *SQSSuite) TestNewSQSWithTransport(c *C) {
+	rt := &cannedTransport{status: http.StatusOK, body: listQueuesXML}
+	testSQS := sqs.NewSQSWithTransport(&sqs.USEast, testCredentials, rt)
+	queues, _, err := testSQS.ListQueues("")
+	c.Assert(err, IsNil)
+	c.Assert(len(queues), Equals, 2)
+	c.Assert(len(rt.requests), Equals, 1)
+	c.Assert(rt.requests[0].URL.Host, Equals, "sqs.us-east-1.amazonaws.com")
+	c.Assert(rt.requests[0].Header.Get("Authorization"), Not(Equals), "")
+}
+
+func (s *SQSSuite) TestNoContentSha256Header(c *C) {
+	rt := &cannedTransport{status: http.StatusOK, body: sendMessageXML}
+	queue := &sqs.Queue{SQS: sqs.NewSQSWithTransport(&sqs.USEast, testCredentials, rt), Name: "TestQueue",
+		Url: "https://sqs.us-east-1.amazonaws.com/123456789012/TestQueue"}
+	_, err := queue.SendMessage("hello", nil)
+	c.Assert(err, IsNil)
+	c.Assert(rt.requests[0].Header.Get("x-amz-content-sha256"), Equals, "")
+	c.Assert(rt.requests[0].Header.Get("Authorization"), Not(Matches), ".*x-amz-content-sha256.*")
+}
+
+func (s *SQSSuite) TestNewSQSWithTransportServerError(c *C) {
+	rt := &cannedTransport{status: http.StatusInternalServerError, body: "Internal Server Error"}
+	testSQS := sqs.NewSQSWithTransport(&sqs.USEast, testCredentials, rt)
+	_, _, err := testSQS.ListQueues("")
+	c.Assert(err, Not(IsNil))
+	_, ok := err.(*sqs.ErrorResponse)
+	c.Assert(ok, Equals, false)
+}
+
+func (s *SQSSuite) TestRegionNames(c *C) {
+	names := sqs.RegionNames()
+	for _, region := range []sqs.Region{sqs.APNortheast, sqs.APSoutheast, sqs.APSoutheast2, sqs.EUWest, sqs.USEast,
+		sqs.USWest, sqs.USWest2, sqs.SAEast, sqs.USGovWest, sqs.CNNorth} {
+		_, ok := sqs.LookupRegion(region.Name)
+		c.Assert(ok, Equals, true)
+	}
+	c.Assert(names[0], Equals, "ap-northeast-1")
+	for i := 1; i < len(names); i++ {
+		c.Assert(names[i-1] < names[i], Equals, true)
+	}
+}
+
+func (s *SQSSuite) TestLookupRegion(c *C) {
+	region, ok := sqs.LookupRegion("us-west-2")
+	c.Assert(ok, Equals, true)
+	c.Assert(*region, Equals, sqs.USWest2)
+
+	region, ok = sqs.LookupRegion("us-nowhere-1")
+	c.Assert(ok, Equals, false)
+	c.Assert(region, IsNil)
+}
+
+func (s *SQSSuite) TestRegionForEndpointHTTP(c *C) {
+	region := sqs.RegionForEndpoint("test-region", s.server.URL+"/")
+	c.Assert(region.Endpoint, Equals, s.server.URL)
+	c.Assert(region.Endpoint, Matches, "http://.*")
+
+	var received *http.Request
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		verifySignature(c, r)
+		respondWith(http.StatusOK, listQueuesXML)(w, r)
+	}
+	sqsClient := &sqs.SQS{Credentials: testCredentials, Region: region, ClientFactory: sqs.DefaultClientFactory}
+	_, _, err := sqsClient.ListQueues("")
+	c.Assert(err, IsNil)
+	c.Assert(received.TLS, IsNil)
+	c.Assert(received.URL.Path, Equals, "/")
+
+	// presigning keeps the scheme, too
+	signedUrl, err := sign4.SignQuery("GET", region.Endpoint+"/", url.Values{"Action": {"ListQueues"}}, testCredentials,
+		region.Name, sqs.SERVICE_NAME, time.Now(), time.Minute)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(signedUrl, s.server.URL+"/?Action=ListQueues&"), Equals, true)
+}
+
+func (s *SQSSuite) TestLookupFIPSRegion(c *C) {
+	region, err := sqs.LookupFIPSRegion("us-east-1", true)
+	c.Assert(err, IsNil)
+	c.Assert(*region, Equals, sqs.Region{Name: "us-east-1", Endpoint: "https://sqs-fips.us-east-1.amazonaws.com"})
+	region, err = sqs.LookupFIPSRegion("us-gov-west-1", true)
+	c.Assert(err, IsNil)
+	c.Assert(region.Endpoint, Equals, sqs.USGovWest.Endpoint)
+
+	// without the flag, the standard endpoint
+	region, err = sqs.LookupFIPSRegion("us-east-1", false)
+	c.Assert(err, IsNil)
+	c.Assert(*region, Equals, sqs.USEast)
+
+	_, err = sqs.LookupFIPSRegion("eu-west-1", true)
+	c.Assert(errors.Is(err, sqs.ErrNoFIPSEndpoint), Equals, true)
+	c.Assert(err, ErrorMatches, "sqs: region has no FIPS endpoint: eu-west-1")
+	// only regions LookupRegion knows, with or without the flag
+	_, err = sqs.LookupFIPSRegion("no-such-region", false)
+	c.Assert(err, ErrorMatches, `sqs.LookupFIPSRegion: Unknown region "no-such-region"`)
+	_, err = sqs.LookupFIPSRegion("us-east-2", true)
+	c.Assert(err, ErrorMatches, `sqs.LookupFIPSRegion: Unknown region "us-east-2"`)
+
+	// a client for the region sends to the FIPS host, signing for the region
+	region, err = sqs.LookupFIPSRegion("us-west-2", true)
+	c.Assert(err, IsNil)
+	rt := &cannedTransport{status: http.StatusOK, body: listQueuesXML}
+	_, _, err = sqs.NewSQSWithTransport(region, testCredentials, rt).ListQueues("")
+	c.Assert(err, IsNil)
+	c.Assert(rt.requests[0].URL.Host, Equals, "sqs-fips.us-west-2.amazonaws.com")
+	c.Assert(rt.requests[0].Header.Get("Authorization"), Matches, ".*/us-west-2/sqs/aws4_request.*")
+}
+
+func (s *SQSSuite) TestNewNearest(c *C) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	sqs.RegisterRegion(sqs.Region{Name: "test-near-down", Endpoint: closed.URL})
+	sqs.RegisterRegion(sqs.Region{Name: "test-near-up", Endpoint: s.server.URL})
+
+	client, err := sqs.NewNearest(testCredentials, []string{"test-near-down", "test-near-up"})
+	c.Assert(err, IsNil)
+	c.Assert(client.Region.Name, Equals, "test-near-up")
+	c.Assert(client.Credentials, Equals, testCredentials)
+
+	// none answers: the first candidate is the default
+	client, err = sqs.NewNearest(testCredentials, []string{"test-near-down"})
+	c.Assert(err, IsNil)
+	c.Assert(client.Region.Name, Equals, "test-near-down")
+
+	_, err = sqs.NewNearest(testCredentials, []string{"test-near-up", "no-such-region"})
+	c.Assert(err, ErrorMatches, `sqs.NewNearest: Unknown region "no-such-region"`)
+	_, err = sqs.NewNearest(testCredentials, nil)
+	c.Assert(err, NotNil)
+}
+
+func (s *SQSSuite) TestRegisterRegion(c *C) {
+	custom := sqs.Region{Name: "test-custom-1", Endpoint: "https://sqs.test-custom-1.example.com"}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			sqs.LookupRegion("test-custom-1")
+			sqs.RegionNames()
+		}
+		close(done)
+	}()
+	sqs.RegisterRegion(custom)
+	<-done
+
+	region, ok := sqs.LookupRegion("test-custom-1")
+	c.Assert(ok, Equals, true)
+	c.Assert(*region, Equals, custom)
+
+	// changing the copy doesn't change the registered region
+	region.Endpoint = "https://elsewhere.example.com"
+	region, _ = sqs.LookupRegion("test-custom-1")
+	c.Assert(region.Endpoint, Equals, custom.Endpoint)
+}
+
+func gzipString(c *C, s string) string {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	_, err := w.Write([]byte(s))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	return buf.String()
+}
+
+func (s *SQSSuite) TestGzipErrorResponse(c *C) {
+	rt := &cannedTransport{status: http.StatusForbidden, body: gzipString(c, accessDeniedXML),
+		header: http.Header{"Content-Encoding": {"gzip"}}}
+	queue := &sqs.Queue{SQS: sqs.NewSQSWithTransport(&sqs.USEast, testCredentials, rt), Name: "TestQueue",
+		Url: "https://sqs.us-east-1.amazonaws.com/123456789012/TestQueue"}
+	_, err := queue.DeleteQueue()
+	errResp, ok := err.(*sqs.ErrorResponse)
+	c.Assert(ok, Equals, true)
+	c.Assert(errResp.Err.Code, Equals, "AccessDenied")
+	c.Assert(string(errResp.RawResponse), Equals, accessDeniedXML)
+}
+
+func (s *SQSSuite) TestGzipStreamedResponse(c *C) {
+	rt := &cannedTransport{status: http.StatusOK, body: gzipString(c, listQueuesXML),
+		header: http.Header{"Content-Encoding": {"gzip"}}}
+	queues, _, err := sqs.NewSQSWithTransport(&sqs.USEast, testCredentials, rt).ListQueues("")
+	c.Assert(err, IsNil)
+	c.Assert(len(queues), Equals, 2)
+}
+
+func (s *SQSSuite) TestClose(c *C) {
+	closed := make(chan bool, 1)
+	server := httptest.NewUnstartedServer(respondWith(http.StatusOK, listQueuesXML))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- true
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	region := &sqs.Region{Name: "test-region", Endpoint: server.URL}
+	testSQS := sqs.NewSQSWithTransport(region, testCredentials, &http.Transport{})
+	_, _, err := testSQS.ListQueues("")
+	c.Assert(err, IsNil)
+
+	testSQS.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		c.Fatal("idle connection was not closed")
+	}
+}
+
+func (s *SQSSuite) TestLegacyEndpoint(c *C) {
+	rt := &cannedTransport{status: http.StatusOK, body: listQueuesXML}
+	testSQS := sqs.NewSQSWithTransport(&sqs.Legacy, testCredentials, rt)
+	_, _, err := testSQS.ListQueues("")
+	c.Assert(err, IsNil)
+	c.Assert(rt.requests[0].URL.Host, Equals, "queue.amazonaws.com")
+	c.Assert(rt.requests[0].Header.Get("Authorization"), Matches, "AWS4-HMAC-SHA256 Credential=WHOAMI/[0-9]{8}/us-east-1/sqs/aws4_request, .*")
+}
+
+func (s *SQSSuite) TestUSEast(c *C) {
+	rt := &cannedTransport{status: http.StatusOK, body: listQueuesXML}
+	_, _, err := sqs.NewSQSWithTransport(&sqs.USEast, testCredentials, rt).ListQueues("")
+	c.Assert(err, IsNil)
+	c.Assert(rt.requests[0].URL.Host, Equals, "sqs.us-east-1.amazonaws.com")
+	c.Assert(rt.requests[0].Header.Get("Authorization"), Matches, "AWS4-HMAC-SHA256 Credential=WHOAMI/[0-9]{8}/us-east-1/sqs/aws4_request, .*")
+}
+
+func (s *SQSSuite) TestNoRegionName(c *C) {
+	rt := &cannedTransport{status: http.StatusOK, body: listQueuesXML}
+	region := &sqs.Region{Endpoint: "https://sqs.us-east-1.amazonaws.com"}
+	_, _, err := sqs.NewSQSWithTransport(region, testCredentials, rt).ListQueues("")
+	c.Assert(err, Equals, sqs.ErrNoRegion)
+	c.Assert(rt.requests, HasLen, 0)
+}
+
+func (s *SQSSuite) TestNewClientFactory(c *C) {
+	client := sqs.NewClientFactory(sqs.TransportOptions{MaxIdleConnsPerHost: 500})()
+	transport := client.Transport.(*http.Transport)
+	c.Assert(transport.MaxIdleConnsPerHost, Equals, 500)
+	c.Assert(transport.MaxIdleConns, Equals, sqs.DefaultTransportOptions.MaxIdleConns)
+	c.Assert(transport.IdleConnTimeout, Equals, sqs.DefaultTransportOptions.IdleConnTimeout)
+	c.Assert(transport.ForceAttemptHTTP2, Equals, true)
+
+	// each client gets its own transport
+	c.Assert(sqs.NewClientFactory(sqs.TransportOptions{})().Transport, Not(Equals), http.DefaultTransport)
+
+	client = sqs.NewClientFactory(sqs.TransportOptions{DisableHTTP2: true})()
+	transport = client.Transport.(*http.Transport)
+	c.Assert(transport.ForceAttemptHTTP2, Equals, false)
+	c.Assert(transport.TLSNextProto, NotNil)
+	c.Assert(transport.TLSNextProto, HasLen, 0)
+}
+
+func (s *SQSSuite) TestNewClientFactorySends(c *C) {
+	s.handler = respondWith(http.StatusOK, listQueuesXML)
+	testSQS := s.testSQS()
+	testSQS.ClientFactory = sqs.NewClientFactory(sqs.DefaultTransportOptions)
+	defer testSQS.Close()
+	queues, _, err := testSQS.ListQueues("")
+	c.Assert(err, IsNil)
+	c.Assert(len(queues), Equals, 2)
+}
+
+func (s *SQSSuite) TestClientFactoryCalledOnce(c *C) {
+	s.handler = respondWith(http.StatusOK, listQueuesXML)
+	calls := 0
+	testSQS := s.testSQS()
+	testSQS.ClientFactory = func() *http.Client {
+		calls++
+		return &http.Client{}
+	}
+	for i := 0; i < 3; i++ {
+		_, _, err := testSQS.ListQueues("")
+		c.Assert(err, IsNil)
+	}
+	c.Assert(calls, Equals, 1)
+}
 
 // LIVE tests; will cost $$ if you run!
 
@@ -62,7 +2271,7 @@ func (s *LiveSQSSuite) SetUpSuite(c *C) {
 		return
 	}
 	s.Credentials = cred
-	s.SQS = &sqs.SQS{s.Credentials, &sqs.USWest, sqs.DefaultClientFactory}
+	s.SQS = &sqs.SQS{Credentials: s.Credentials, Region: &sqs.USWest, ClientFactory: sqs.DefaultClientFactory}
 
 	testQueue, _, err := s.createLiveQueue(QUEUE_NAME_PREFIX + "LiveTestQueue_" + time.Now().Format(TIMESTAMP_FMT))
 	if err != nil {
@@ -74,14 +2283,11 @@ func (s *LiveSQSSuite) SetUpSuite(c *C) {
 }
 
 func (s *LiveSQSSuite) TearDownSuite(c *C) {
-	queues, _, err := s.SQS.ListQueues(QUEUE_NAME_PREFIX)
-	if err != nil {
-		c.Log(err)
+	_, errs := s.SQS.DeleteQueuesByPrefix(QUEUE_NAME_PREFIX)
+	if len(errs) > 0 {
+		c.Log(errs)
 		c.Fatal("Could not delete live queues in TearDownSuite, check account for live queues.")
 	}
-	for _, q := range queues {
-		q.DeleteQueue()
-	}
 }
 
 const (
@@ -0,0 +1,112 @@
+// Client for the AWS Security Token Service (STS).
+//
+// Clients use the regional STS endpoint (sts.<region>.amazonaws.com) by default, rather than the
+// global sts.amazonaws.com: regional endpoints have lower latency, and work in opt-in regions that
+// the global endpoint doesn't serve.
+package sts
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/p-lewis/awsgolang/auth"
+	"github.com/p-lewis/awsgolang/awsclient"
+	"github.com/p-lewis/awsgolang/sign4"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	AWS_API_VERSION = "2011-06-15"
+	SERVICE_NAME    = "sts"
+	GLOBAL_ENDPOINT = "https://sts.amazonaws.com" // signed for sign4.GlobalRegion
+)
+
+// Get the regional STS endpoint for a region, e.g. "https://sts.us-west-2.amazonaws.com".
+func RegionalEndpoint(region string) string {
+	if strings.HasPrefix(region, "cn-") {
+		return fmt.Sprintf("https://sts.%s.amazonaws.com.cn", region)
+	}
+	return fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+}
+
+// The STS type encapsulates operations with STS. Change the Client's Endpoint (and Region) to use
+// a different endpoint.
+type STS struct {
+	*awsclient.Client
+}
+
+// Create an STS for the region, using the regional endpoint.
+func New(cred *auth.Credentials, region string) *STS {
+	return &STS{awsclient.New(cred, region, SERVICE_NAME, RegionalEndpoint(region))}
+}
+
+// Create an STS that uses GLOBAL_ENDPOINT, signing for sign4.GlobalRegion. Prefer New: the
+// global endpoint is only in us-east-1, and is the default only for older SDKs.
+func NewGlobal(cred *auth.Credentials) *STS {
+	return &STS{awsclient.New(cred, sign4.GlobalRegion, SERVICE_NAME, GLOBAL_ENDPOINT)}
+}
+
+// Get details about the credentials used to call STS.
+func (sts *STS) GetCallerIdentity() (*GetCallerIdentityResponse, error) {
+	gciResponse := &GetCallerIdentityResponse{}
+	err := sts.postResults("GetCallerIdentity", url.Values{}, gciResponse)
+	if err != nil {
+		return nil, err
+	}
+	return gciResponse, nil
+}
+
+// POST the action and values to the endpoint, and unmarshal the results.
+func (sts *STS) postResults(action string, values url.Values, goodResponse interface{}) error {
+	values.Set("Action", action)
+	values.Set("Version", AWS_API_VERSION)
+	req, err := sts.NewRequest("POST", "/", strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := sts.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return xml.Unmarshal(body, goodResponse)
+	}
+	errResponse := &ErrorResponse{StatusCode: resp.StatusCode}
+	if err = xml.Unmarshal(body, errResponse); err != nil {
+		return fmt.Errorf("sts.postResults: Unable to unmarshal body data to %T, Status: %v", errResponse, resp.Status)
+	}
+	return errResponse
+}
+
+type GetCallerIdentityResponse struct {
+	XMLName   xml.Name `xml:"GetCallerIdentityResponse"` //https://sts.amazonaws.com/doc/2011-06-15/
+	Arn       string   `xml:"GetCallerIdentityResult>Arn"`
+	UserId    string   `xml:"GetCallerIdentityResult>UserId"`
+	Account   string   `xml:"GetCallerIdentityResult>Account"`
+	RequestId string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type ErrorResponse struct {
+	XMLName    xml.Name  `xml:"ErrorResponse"` //https://sts.amazonaws.com/doc/2011-06-15/
+	Err        ErrorInfo `xml:"Error"`
+	RequestId  string
+	StatusCode int `xml:"-"`
+}
+
+type ErrorInfo struct {
+	Type, Code, Message string
+}
+
+func (e *ErrorResponse) Error() string {
+	return fmt.Sprintf("sts.ErrorResponse Type: %v, Code: %v Message: %v",
+		e.Err.Type, e.Err.Code, e.Err.Message)
+}
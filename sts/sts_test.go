@@ -0,0 +1,79 @@
+package sts_test
+
+import (
+	"github.com/p-lewis/awsgolang/auth"
+	"github.com/p-lewis/awsgolang/sts"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type STSSuite struct{}
+
+var _ = Suite(&STSSuite{})
+
+var testCredentials = &auth.Credentials{AccessKey: "WHOAMI", SecretKey: "ITSASECRET"}
+
+const getCallerIdentityXML = `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:iam::123456789012:user/Alice</Arn>
+    <UserId>AKIAI44QH8DHBEXAMPLE</UserId>
+    <Account>123456789012</Account>
+  </GetCallerIdentityResult>
+  <ResponseMetadata>
+    <RequestId>01234567-89ab-cdef-0123-456789abcdef</RequestId>
+  </ResponseMetadata>
+</GetCallerIdentityResponse>`
+
+func (s *STSSuite) TestRegionalEndpointByDefault(c *C) {
+	c.Assert(sts.New(testCredentials, "ap-east-1").Endpoint, Equals, "https://sts.ap-east-1.amazonaws.com")
+	c.Assert(sts.New(testCredentials, "cn-north-1").Endpoint, Equals, "https://sts.cn-north-1.amazonaws.com.cn")
+}
+
+func (s *STSSuite) TestNewGlobal(c *C) {
+	client := sts.NewGlobal(testCredentials)
+	c.Assert(client.Endpoint, Equals, sts.GLOBAL_ENDPOINT)
+	c.Assert(client.Region, Equals, "us-east-1")
+}
+
+func (s *STSSuite) TestGetCallerIdentity(c *C) {
+	var authz string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authz = r.Header.Get("Authorization")
+		r.ParseForm()
+		if r.Form.Get("Action") != "GetCallerIdentity" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte(getCallerIdentityXML))
+	}))
+	defer server.Close()
+
+	client := sts.New(testCredentials, "eu-west-1")
+	client.Endpoint = server.URL
+	resp, err := client.GetCallerIdentity()
+	c.Assert(err, IsNil)
+	c.Assert(resp.Account, Equals, "123456789012")
+	c.Assert(resp.Arn, Equals, "arn:aws:iam::123456789012:user/Alice")
+	c.Assert(authz, Matches, "AWS4-HMAC-SHA256 Credential=WHOAMI/[0-9]{8}/eu-west-1/sts/aws4_request, .*")
+}
+
+func (s *STSSuite) TestGetCallerIdentityError(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>InvalidClientTokenId</Code>` +
+			`<Message>The security token included in the request is invalid.</Message></Error>` +
+			`<RequestId>f9f5a2b9-3e4c-4f0e-9b1d-2a2f5d3b7e51</RequestId></ErrorResponse>`))
+	}))
+	defer server.Close()
+
+	client := sts.New(testCredentials, "eu-west-1")
+	client.Endpoint = server.URL
+	_, err := client.GetCallerIdentity()
+	errResp, ok := err.(*sts.ErrorResponse)
+	c.Assert(ok, Equals, true)
+	c.Assert(errResp.Err.Code, Equals, "InvalidClientTokenId")
+	c.Assert(errResp.StatusCode, Equals, 403)
+}